@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommie/chargen2p"
+)
+
+var (
+	checkSentBytes     *prometheus.CounterVec
+	checkReceivedBytes *prometheus.CounterVec
+	checkPaused        *prometheus.GaugeVec
+)
+
+func init() {
+	registerCheckVecs(func(keys []string) []prometheus.Collector {
+		checkSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "check_sent_bytes",
+			Help:      "Payload bytes sent by checks.",
+		}, labelNames(serviceLabelNames, keys))
+		checkReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "check_received_bytes",
+			Help:      "Payload bytes received by checks.",
+		}, labelNames(serviceLabelNames, keys))
+		checkPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "check_paused",
+			Help:      "Whether a check is paused, by reason: check_budget or interface_budget.",
+		}, labelNames(serviceLabelNames, keys, "reason"))
+
+		return []prometheus.Collector{checkSentBytes, checkReceivedBytes, checkPaused}
+	})
+}
+
+// Values of the reason label of connectivity_check_paused.
+const (
+	pauseCheckBudget     = "check_budget"
+	pauseInterfaceBudget = "interface_budget"
+)
+
+// pauseReasons are the values of the reason label.
+var pauseReasons = []string{pauseCheckBudget, pauseInterfaceBudget}
+
+// statePaused is the state of a check run that didn't happen because
+// a budget was exhausted.
+const statePaused = "paused"
+
+// icmpEchoHeaderSize is the size of the ICMP echo header, before the
+// payload.
+const icmpEchoHeaderSize = 8
+
+// echoBytes returns the size of n ICMP echo messages.
+func echoBytes(n int) float64 {
+	return float64(n * (icmpEchoHeaderSize + pingPayloadSize))
+}
+
+// A budgetPeriod is when the usage of a budget resets.
+type budgetPeriod int
+
+const (
+	budgetDaily budgetPeriod = iota
+	budgetMonthly
+)
+
+// A dataBudget is how many bytes, sent and received, that may be used
+// in a period.
+type dataBudget struct {
+	Bytes  int64
+	Period budgetPeriod
+}
+
+// byteUnits are the size suffixes understood by parseDataBudget.
+var byteUnits = []struct {
+	Suffix string
+	Size   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"kB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// parseDataBudget parses a budget like "500MB/day" or "2GiB/month".
+func parseDataBudget(s string) (*dataBudget, error) {
+	ss := strings.SplitN(s, "/", 2)
+	if len(ss) != 2 {
+		return nil, fmt.Errorf("expected SIZE/day or SIZE/month in budget: %q", s)
+	}
+
+	var b dataBudget
+	switch ss[1] {
+	case "day":
+		b.Period = budgetDaily
+	case "month":
+		b.Period = budgetMonthly
+	default:
+		return nil, fmt.Errorf("unknown budget period %q: %q", ss[1], s)
+	}
+
+	size, unit := ss[0], int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(size, u.Suffix) {
+			size, unit = strings.TrimSuffix(size, u.Suffix), u.Size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid size in budget: %q", s)
+	}
+	b.Bytes = int64(n * float64(unit))
+	return &b, nil
+}
+
+// start returns the start of the period that t is in, in local time.
+func (b *dataBudget) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if b.Period == budgetMonthly {
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// interfaceBudgetsFlag is a flag value of budgets by interface name,
+// in the format INTERFACE=BUDGET.
+type interfaceBudgetsFlag map[string]*dataBudget
+
+func interfaceBudgetFlag(name, usage string) map[string]*dataBudget {
+	f := interfaceBudgetsFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f interfaceBudgetsFlag) String() string {
+	return ""
+}
+
+func (f interfaceBudgetsFlag) Set(s string) error {
+	ss := strings.SplitN(s, "=", 2)
+	if len(ss) != 2 || ss[0] == "" {
+		return fmt.Errorf("expected INTERFACE=BUDGET: %q", s)
+	}
+	b, err := parseDataBudget(ss[1])
+	if err != nil {
+		return err
+	}
+	f[ss[0]] = b
+	return nil
+}
+
+// A budgetUsage is the bytes used in the current period of a budget.
+type budgetUsage struct {
+	start time.Time
+	bytes int64
+}
+
+// A budgetMeter tracks the data usage of checks and interfaces
+// against their budgets. Usage is kept in memory, and restored from
+// the history file at startup.
+type budgetMeter struct {
+	// interfaces are the budgets of all checks bound to an
+	// interface.
+	interfaces map[string]*dataBudget
+
+	mu sync.Mutex
+	// checks are keyed by historyKey, so they can be restored from
+	// history records.
+	checks map[string]*budgetUsage
+	ifaces map[string]*budgetUsage
+}
+
+// budgets is the meter of all running checks.
+var budgets = newBudgetMeter(nil)
+
+func newBudgetMeter(interfaces map[string]*dataBudget) *budgetMeter {
+	return &budgetMeter{
+		interfaces: interfaces,
+		checks:     map[string]*budgetUsage{},
+		ifaces:     map[string]*budgetUsage{},
+	}
+}
+
+// add records bytes used by a check run that ended at now.
+func (bm *budgetMeter) add(chk *ConnectivityCheck, now time.Time, n int64) {
+	bm.addUsage(chk.historyKey(), chk.Budget, chk.Socket.Interface, now, n)
+}
+
+// addUsage records bytes used at now by the check with the key and
+// budget, bound to the interface.
+func (bm *budgetMeter) addUsage(key string, b *dataBudget, iface string, now time.Time, n int64) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if b != nil {
+		u := currentUsage(bm.checks[key], b, now)
+		u.bytes += n
+		bm.checks[key] = u
+	}
+	if b := bm.interfaces[iface]; b != nil && iface != "" {
+		u := currentUsage(bm.ifaces[iface], b, now)
+		u.bytes += n
+		bm.ifaces[iface] = u
+	}
+}
+
+// exhausted returns the reason the check must not run at now, or an
+// empty string if its budgets allow it.
+func (bm *budgetMeter) exhausted(chk *ConnectivityCheck, now time.Time) string {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if chk.Budget != nil && currentUsage(bm.checks[chk.historyKey()], chk.Budget, now).bytes >= chk.Budget.Bytes {
+		return pauseCheckBudget
+	}
+	if b := bm.interfaces[chk.Socket.Interface]; b != nil && chk.Socket.Interface != "" && currentUsage(bm.ifaces[chk.Socket.Interface], b, now).bytes >= b.Bytes {
+		return pauseInterfaceBudget
+	}
+	return ""
+}
+
+// restore adds the data usage recorded in the history file during
+// the current periods of the budgets, so it survives restarts. Runs of
+// checks that are no longer configured still count against the budget
+// of their interface.
+func (bm *budgetMeter) restore(path string, checks []ConnectivityCheck, now time.Time) error {
+	from := now
+	budgeted := map[string]*dataBudget{}
+	for i := range checks {
+		if b := checks[i].Budget; b != nil {
+			budgeted[checks[i].historyKey()] = b
+			if start := b.start(now); start.Before(from) {
+				from = start
+			}
+		}
+	}
+	for _, b := range bm.interfaces {
+		if start := b.start(now); start.Before(from) {
+			from = start
+		}
+	}
+	if !from.Before(now) {
+		return nil
+	}
+
+	err := readHistory(path, from, now, func(rec *historyRecord) error {
+		n := int64(rec.Values["sent_bytes"] + rec.Values["received_bytes"])
+		if n == 0 {
+			return nil
+		}
+		key := rec.key()
+		bm.addUsage(key, budgeted[key], rec.Interface, rec.Time.In(now.Location()), n)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// currentUsage returns u if it's for the current period of the
+// budget, or a new usage.
+func currentUsage(u *budgetUsage, b *dataBudget, now time.Time) *budgetUsage {
+	start := b.start(now)
+	if u == nil || !u.start.Equal(start) {
+		return &budgetUsage{start: start}
+	}
+	return u
+}
+
+// recordDataUsage updates the data usage counters and budgets from the
+// values of a check run.
+func recordDataUsage(chk *ConnectivityCheck, now time.Time, vals map[string]float64) {
+	sent, recv := vals["sent_bytes"], vals["received_bytes"]
+	if sent == 0 && recv == 0 {
+		return
+	}
+	checkSentBytes.WithLabelValues(chk.serviceLabels()...).Add(sent)
+	checkReceivedBytes.WithLabelValues(chk.serviceLabels()...).Add(recv)
+	budgets.add(chk, now, int64(sent+recv))
+}
+
+// A countingDialer counts the bytes written to and read from the
+// connections it dials, so failed transfers still account for the
+// data they used.
+type countingDialer struct {
+	chargen2p.NetDialer
+
+	nw, nr int64
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.NetDialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, d: d}, nil
+}
+
+// written returns the number of bytes written to all connections.
+func (d *countingDialer) written() int64 {
+	return atomic.LoadInt64(&d.nw)
+}
+
+// read returns the number of bytes read from all connections.
+func (d *countingDialer) read() int64 {
+	return atomic.LoadInt64(&d.nr)
+}
+
+// A countingConn adds the bytes it transfers to its dialer.
+type countingConn struct {
+	net.Conn
+
+	d *countingDialer
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.d.nr, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.d.nw, int64(n))
+	return n, err
+}
+
+// CloseWrite shuts down the writing side, as chargen2p requires.
+func (c *countingConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return fmt.Errorf("connection can't close for writing: %T", c.Conn)
+	}
+	return cw.CloseWrite()
+}
+
+// setPaused updates the pause metric of the check. The reason is
+// empty if the check isn't paused.
+func setPaused(chk *ConnectivityCheck, reason string) {
+	for _, r := range pauseReasons {
+		v := 0.0
+		if r == reason {
+			v = 1
+		}
+		checkPaused.WithLabelValues(append(chk.serviceLabels(), r)...).Set(v)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseDataBudget(t *testing.T) {
+	tsts := []struct {
+		In      string
+		Want    dataBudget
+		WantErr string
+	}{
+		{"100/day", dataBudget{Bytes: 100, Period: budgetDaily}, ""},
+		{"500MB/day", dataBudget{Bytes: 500e6, Period: budgetDaily}, ""},
+		{"1.5GB/month", dataBudget{Bytes: 1.5e9, Period: budgetMonthly}, ""},
+		{"2GiB/month", dataBudget{Bytes: 2 << 30, Period: budgetMonthly}, ""},
+		{"10kB/day", dataBudget{Bytes: 10e3, Period: budgetDaily}, ""},
+
+		{"100", dataBudget{}, "expected SIZE/day"},
+		{"100/week", dataBudget{}, "unknown budget period"},
+		{"xMB/day", dataBudget{}, "invalid size"},
+		{"0B/day", dataBudget{}, "invalid size"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.In, func(t *testing.T) {
+			got, err := parseDataBudget(tst.In)
+			if tst.WantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tst.WantErr) {
+					t.Fatalf("parseDataBudget err: got %v, want %q", err, tst.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDataBudget failed: %v", err)
+			}
+			if *got != tst.Want {
+				t.Errorf("parseDataBudget: got %+v, want %+v", *got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestBudgetMeter(t *testing.T) {
+	day := time.Date(2021, 3, 3, 10, 0, 0, 0, time.Local)
+
+	t.Run("check", func(t *testing.T) {
+		bm := newBudgetMeter(nil)
+		chk := &ConnectivityCheck{Kind: KindTransfer, Host: "a", Budget: &dataBudget{Bytes: 1000, Period: budgetDaily}}
+
+		bm.add(chk, day, 999)
+		if got := bm.exhausted(chk, day); got != "" {
+			t.Errorf("exhausted: got %q, want none", got)
+		}
+		bm.add(chk, day, 1)
+		if got := bm.exhausted(chk, day); got != pauseCheckBudget {
+			t.Errorf("exhausted: got %q, want %q", got, pauseCheckBudget)
+		}
+		if got := bm.exhausted(chk, day.AddDate(0, 0, 1)); got != "" {
+			t.Errorf("exhausted the next day: got %q, want none", got)
+		}
+	})
+
+	t.Run("month", func(t *testing.T) {
+		bm := newBudgetMeter(nil)
+		chk := &ConnectivityCheck{Kind: KindTransfer, Host: "a", Budget: &dataBudget{Bytes: 1000, Period: budgetMonthly}}
+
+		bm.add(chk, day, 1000)
+		if got := bm.exhausted(chk, day.AddDate(0, 0, 20)); got != pauseCheckBudget {
+			t.Errorf("exhausted later in the month: got %q, want %q", got, pauseCheckBudget)
+		}
+		if got := bm.exhausted(chk, day.AddDate(0, 1, 0)); got != "" {
+			t.Errorf("exhausted the next month: got %q, want none", got)
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		bm := newBudgetMeter(map[string]*dataBudget{"wwan0": {Bytes: 1000, Period: budgetDaily}})
+		chk1 := &ConnectivityCheck{Kind: KindTransfer, Host: "a", Socket: SocketOptions{Interface: "wwan0"}}
+		chk2 := &ConnectivityCheck{Kind: KindHostFloodPing, Host: "b", Socket: SocketOptions{Interface: "wwan0"}}
+		chk3 := &ConnectivityCheck{Kind: KindHostFloodPing, Host: "b"}
+
+		bm.add(chk1, day, 600)
+		bm.add(chk2, day, 400)
+		bm.add(chk3, day, 1e6)
+		if got := bm.exhausted(chk2, day); got != pauseInterfaceBudget {
+			t.Errorf("exhausted: got %q, want %q", got, pauseInterfaceBudget)
+		}
+		if got := bm.exhausted(chk3, day); got != "" {
+			t.Errorf("exhausted without interface: got %q, want none", got)
+		}
+	})
+}
+
+func TestBudgetMeterRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	now := time.Date(2021, 3, 3, 10, 0, 0, 0, time.Local)
+	chk := &ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "a", Service: "echo", Socket: SocketOptions{Interface: "wwan0"}, Budget: &dataBudget{Bytes: 1000, Period: budgetDaily}}
+	gone := &ConnectivityCheck{Kind: KindHostFloodPing, Network: "ip", Host: "b", Socket: SocketOptions{Interface: "wwan0"}}
+
+	bm := newBudgetMeter(map[string]*dataBudget{"wwan0": {Bytes: 2000, Period: budgetMonthly}})
+	if err := bm.restore(path, []ConnectivityCheck{*chk}, now); err != nil {
+		t.Fatalf("restore without history failed: %v", err)
+	}
+
+	w, err := openHistory(path, 1<<20, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("openHistory failed: %v", err)
+	}
+	for _, rec := range []*historyRecord{
+		newHistoryRecord(chk, now.AddDate(0, 0, -1), time.Second, stateOK, map[string]float64{"sent_bytes": 500, "received_bytes": 500}, nil),
+		newHistoryRecord(chk, now.Add(-time.Hour), time.Second, stateFailed, map[string]float64{"sent_bytes": 300, "received_bytes": 300}, errors.New("mocked failure")),
+		newHistoryRecord(gone, now.Add(-time.Hour), time.Second, stateOK, map[string]float64{"sent_bytes": 200}, nil),
+	} {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	bm = newBudgetMeter(map[string]*dataBudget{"wwan0": {Bytes: 2000, Period: budgetMonthly}})
+	if err := bm.restore(path, []ConnectivityCheck{*chk}, now); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if got := bm.exhausted(chk, now); got != "" {
+		t.Errorf("exhausted: got %q, want none", got)
+	}
+	// Yesterday's run only counts against the monthly interface budget.
+	bm.add(chk, now, 400)
+	if got := bm.exhausted(chk, now); got != pauseCheckBudget {
+		t.Errorf("exhausted: got %q, want %q", got, pauseCheckBudget)
+	}
+	bm.add(gone, now, 1)
+	if got := bm.exhausted(gone, now); got != pauseInterfaceBudget {
+		t.Errorf("exhausted: got %q, want %q", got, pauseInterfaceBudget)
+	}
+}
+
+func TestRunCheckOncePaused(t *testing.T) {
+	defer func(bm *budgetMeter) {
+		budgets = bm
+	}(budgets)
+	budgets = newBudgetMeter(nil)
+
+	chk := &ConnectivityCheck{Kind: KindHostFloodPing, Network: "ip", Host: "127.0.0.4", Budget: &dataBudget{Bytes: 1000, Period: budgetDaily}}
+	chkr := &fakeChecker{}
+	ot := newOutageTracker(chk)
+	if got := runCheckOnce(context.Background(), chk, chkr, ot); got != stateOK {
+		t.Fatalf("runCheckOnce: got %q, want %q", got, stateOK)
+	}
+	if got := testutil.ToFloat64(checkSentBytes.WithLabelValues(chk.serviceLabels()...)); got == 0 {
+		t.Errorf("checkSentBytes: got %v, want >0", got)
+	}
+
+	budgets.add(chk, time.Now(), 1000)
+	if got := runCheckOnce(context.Background(), chk, chkr, ot); got != statePaused {
+		t.Fatalf("runCheckOnce: got %q, want %q", got, statePaused)
+	}
+	if chkr.NumPingCalls != 1 {
+		t.Errorf("CheckPing calls: got %d, want 1", chkr.NumPingCalls)
+	}
+	if got := testutil.ToFloat64(checkPaused.WithLabelValues(append(chk.serviceLabels(), pauseCheckBudget)...)); got != 1 {
+		t.Errorf("checkPaused: got %v, want 1", got)
+	}
+}
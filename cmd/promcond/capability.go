@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var exporterCapability = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "connectivity",
+	Name:      "exporter_capability",
+	Help:      "Whether the exporter has a capability it needs for some checks.",
+}, []string{"name"})
+
+func init() {
+	prometheus.MustRegister(exporterCapability)
+}
+
+// capabilities are what the process is allowed to do, as detected at
+// startup.
+type capabilities struct {
+	// UnprivilegedICMP is whether ICMP datagram sockets can be
+	// opened.
+	UnprivilegedICMP bool
+
+	// RawICMP is whether raw ICMP sockets can be opened.
+	RawICMP bool
+
+	// NetRaw is CAP_NET_RAW, needed for ARP/NDP checks.
+	NetRaw bool
+
+	// NetAdmin is CAP_NET_ADMIN, needed for socket marks.
+	NetAdmin bool
+
+	// SysAdmin is CAP_SYS_ADMIN, needed for network namespaces.
+	SysAdmin bool
+}
+
+// export sets the capability metric.
+func (c *capabilities) export() {
+	for name, ok := range map[string]bool{
+		"unprivileged_icmp": c.UnprivilegedICMP,
+		"raw_icmp":          c.RawICMP,
+		"net_raw":           c.NetRaw,
+		"net_admin":         c.NetAdmin,
+		"sys_admin":         c.SysAdmin,
+	} {
+		v := 0.0
+		if ok {
+			v = 1
+		}
+		exporterCapability.WithLabelValues(name).Set(v)
+	}
+}
+
+// applyCapabilities selects the ICMP socket type, and returns the
+// checks that can run. Other checks are logged and dropped, instead
+// of failing at every interval. Checks that depend on a dropped check
+// are dropped too, since they would never be skipped.
+func applyCapabilities(c *capabilities, checks []ConnectivityCheck) []ConnectivityCheck {
+	selectICMPMode(c)
+
+	disabled := map[string]bool{}
+	var ret []ConnectivityCheck
+	for _, chk := range checks {
+		if reason := missingCapability(c, &chk); reason != "" {
+			log.Printf("Disabling check %s: %s.", chk.describe(), reason)
+			if chk.Name != "" {
+				disabled[chk.Name] = true
+			}
+			continue
+		}
+		ret = append(ret, chk)
+	}
+
+	for changed := len(disabled) > 0; changed; {
+		changed = false
+		var kept []ConnectivityCheck
+		for _, chk := range ret {
+			if disabled[chk.DependsOn] {
+				log.Printf("Disabling check %s: it depends on disabled check %s.", chk.describe(), chk.DependsOn)
+				if chk.Name != "" {
+					disabled[chk.Name] = true
+				}
+				changed = true
+				continue
+			}
+			kept = append(kept, chk)
+		}
+		ret = kept
+	}
+	return ret
+}
+
+// selectICMPMode falls back to raw ICMP sockets if unprivileged ones
+// aren't allowed.
+func selectICMPMode(c *capabilities) {
+	if !icmpPrivileged && !c.UnprivilegedICMP && c.RawICMP {
+		log.Printf("Unprivileged ICMP sockets are not allowed by net.ipv4.ping_group_range. Using raw ICMP sockets for ping checks.")
+		icmpPrivileged = true
+	}
+}
+
+// missingCapability returns why the check can't run, or an empty
+// string if it can.
+func missingCapability(c *capabilities, chk *ConnectivityCheck) string {
+	switch {
+	case (chk.Kind == KindHostPing || chk.Kind == KindHostFloodPing) && icmpPrivileged && !c.RawICMP:
+		return "raw ICMP sockets need CAP_NET_RAW"
+	case (chk.Kind == KindHostPing || chk.Kind == KindHostFloodPing) && !icmpPrivileged && !c.UnprivilegedICMP:
+		return "ICMP sockets need net.ipv4.ping_group_range to include the group, or CAP_NET_RAW"
+	case chk.Kind == KindNeighbor && !c.NetRaw:
+		return "ARP/NDP needs CAP_NET_RAW"
+	case chk.Socket.Mark != 0 && !c.NetAdmin:
+		return "socket marks need CAP_NET_ADMIN"
+	case chk.Socket.Netns != "" && !c.SysAdmin:
+		return "network namespaces need CAP_SYS_ADMIN"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// procSelfStatus is the status of the process, with its capability
+// sets. It's a test injection point.
+var procSelfStatus = "/proc/self/status"
+
+// detectCapabilities probes which sockets can be opened, and reads the
+// effective capabilities of the process.
+func detectCapabilities() *capabilities {
+	c := &capabilities{
+		UnprivilegedICMP: canOpenSocket(unix.SOCK_DGRAM),
+		RawICMP:          canOpenSocket(unix.SOCK_RAW),
+	}
+
+	f, err := os.Open(procSelfStatus)
+	if err != nil {
+		log.Printf("Failed to read capabilities: %v", err)
+		return c
+	}
+	defer f.Close()
+
+	caps, err := parseCapEff(f)
+	if err != nil {
+		log.Printf("Failed to read capabilities: %v", err)
+		return c
+	}
+	c.NetRaw = caps&(1<<unix.CAP_NET_RAW) != 0
+	c.NetAdmin = caps&(1<<unix.CAP_NET_ADMIN) != 0
+	c.SysAdmin = caps&(1<<unix.CAP_SYS_ADMIN) != 0
+	return c
+}
+
+// canOpenSocket returns whether an IPv4 ICMP socket of the type can be
+// opened.
+func canOpenSocket(sotype int) bool {
+	fd, err := unix.Socket(unix.AF_INET, sotype|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return false
+	}
+	unix.Close(fd)
+	return true
+}
+
+// parseCapEff returns the effective capability set from the format of
+// /proc/self/status.
+//
+//  CapEff:	0000003fffffffff
+func parseCapEff(r io.Reader) (uint64, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if v := strings.TrimPrefix(s.Text(), "CapEff:"); v != s.Text() {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.ErrUnexpectedEOF
+}
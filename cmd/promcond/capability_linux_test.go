@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCapEff(t *testing.T) {
+	got, err := parseCapEff(strings.NewReader("Name:\tpromcond\nCapInh:\t0000000000000000\nCapPrm:\t0000000000003000\nCapEff:\t0000000000003000\n"))
+	if err != nil {
+		t.Fatalf("parseCapEff failed: %v", err)
+	}
+	if want := uint64(1<<12 | 1<<13); got != want {
+		t.Errorf("parseCapEff: got %#x, want %#x", got, want)
+	}
+
+	if _, err := parseCapEff(strings.NewReader("Name:\tpromcond\n")); err == nil {
+		t.Errorf("parseCapEff: got nil error, want error")
+	}
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	if os.Getenv("CI") == "true" {
+		t.Skip("Ping test requires privileges CircleCI/Docker doesn't provide")
+	}
+
+	c := detectCapabilities()
+	// The tests need unprivileged ICMP anyway.
+	if !c.UnprivilegedICMP {
+		t.Errorf("UnprivilegedICMP: got false, want true")
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/net/icmp"
+)
+
+// detectCapabilities probes which sockets can be opened. Without Linux
+// capabilities, root is assumed to be allowed everything.
+func detectCapabilities() *capabilities {
+	root := os.Geteuid() == 0
+	return &capabilities{
+		UnprivilegedICMP: canListen("udp4"),
+		RawICMP:          canListen("ip4:icmp"),
+		NetRaw:           root,
+		NetAdmin:         root,
+		SysAdmin:         root,
+	}
+}
+
+// canListen returns whether an ICMP socket can be opened.
+func canListen(network string) bool {
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
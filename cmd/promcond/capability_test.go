@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyCapabilities(t *testing.T) {
+	defer func() {
+		icmpPrivileged = false
+	}()
+
+	checks := []ConnectivityCheck{
+		{Kind: KindHostPing, Host: "a"},
+		{Kind: KindNeighbor, Host: "b"},
+		{Kind: KindConnect, Host: "c", Socket: SocketOptions{Mark: 1}},
+		{Kind: KindConnect, Host: "d", Socket: SocketOptions{Netns: "blue"}},
+		{Kind: KindConnect, Host: "e"},
+	}
+
+	tsts := []struct {
+		Name           string
+		Caps           capabilities
+		WantHosts      []string
+		WantPrivileged bool
+	}{
+		{"all", capabilities{UnprivilegedICMP: true, RawICMP: true, NetRaw: true, NetAdmin: true, SysAdmin: true}, []string{"a", "b", "c", "d", "e"}, false},
+		{"rawFallback", capabilities{RawICMP: true, NetRaw: true}, []string{"a", "b", "e"}, true},
+		{"none", capabilities{}, []string{"e"}, false},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			icmpPrivileged = false
+
+			got := applyCapabilities(&tst.Caps, checks)
+
+			var hosts []string
+			for _, chk := range got {
+				hosts = append(hosts, chk.Host)
+			}
+			if len(hosts) != len(tst.WantHosts) {
+				t.Fatalf("applyCapabilities: got %v, want %v", hosts, tst.WantHosts)
+			}
+			for i := range hosts {
+				if hosts[i] != tst.WantHosts[i] {
+					t.Errorf("applyCapabilities: got %v, want %v", hosts, tst.WantHosts)
+					break
+				}
+			}
+			if icmpPrivileged != tst.WantPrivileged {
+				t.Errorf("icmpPrivileged: got %v, want %v", icmpPrivileged, tst.WantPrivileged)
+			}
+		})
+	}
+}
+
+func TestApplyCapabilitiesDependents(t *testing.T) {
+	defer func() {
+		icmpPrivileged = false
+	}()
+
+	checks := []ConnectivityCheck{
+		{Name: "gw", Kind: KindNeighbor, Host: "a"},
+		{Name: "inet", Kind: KindConnect, Host: "b", DependsOn: "gw"},
+		{Kind: KindConnect, Host: "c", DependsOn: "inet"},
+		{Name: "other", Kind: KindConnect, Host: "d"},
+		{Kind: KindConnect, Host: "e", DependsOn: "other"},
+	}
+	got := applyCapabilities(&capabilities{UnprivilegedICMP: true}, checks)
+
+	var hosts []string
+	for _, chk := range got {
+		hosts = append(hosts, chk.Host)
+	}
+	if want := []string{"d", "e"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("applyCapabilities: got %v, want %v", hosts, want)
+	}
+	if err := validateDependencies(got); err != nil {
+		t.Errorf("validateDependencies failed: %v", err)
+	}
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-ping/ping"
@@ -19,196 +20,485 @@ var (
 	// injection point.
 	pingInterval = 1 * time.Second
 
-	checkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "connectivity",
-		Name:      "check_failures",
-		Help:      "Failures during checks.",
-	}, []string{"af", "host", "service", "kind"})
-
-	// In this case, reporting the ratio itself is probably
-	// right. I can't see that we'd want this weighted by number
-	// of packages rather than by host.
-	hostPacketLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "connectivity",
-		Name:      "host_packet_loss",
-		Help:      "Packet loss between instance and remote host.",
-	}, []string{"af", "host"})
-	hostRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "connectivity",
-		Name:      "host_rtt",
-		Help:      "RTT between instance and remote host.",
-	}, []string{"af", "host"})
-	serviceLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "connectivity",
-		Name:      "service_latency",
-		Help:      "Latency between the instance and a remote service.",
-	}, []string{"af", "host", "service", "kind"})
-	serviceThroughput = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "connectivity",
-		Name:      "service_throughput",
-		Help:      "Whether the instance can use a remote service.",
-	}, []string{"af", "host", "service", "kind"})
+	checkFailures      *prometheus.CounterVec
+	checkSkips         *prometheus.CounterVec
+	checkInterval      *prometheus.GaugeVec
+	checkNextRun       *prometheus.GaugeVec
+	checkStatus        *prometheus.GaugeVec
+	hostPacketLoss     *prometheus.GaugeVec
+	hostRTT            *prometheus.GaugeVec
+	serviceLatency     *prometheus.GaugeVec
+	serviceThroughput  *prometheus.GaugeVec
+	neighborPacketLoss *prometheus.GaugeVec
+	neighborRTT        *prometheus.GaugeVec
+	neighborInfo       *prometheus.GaugeVec
+	neighborChanges    *prometheus.CounterVec
 )
 
 func init() {
-	prometheus.MustRegister(checkFailures)
-	prometheus.MustRegister(hostPacketLoss)
-	prometheus.MustRegister(hostRTT)
-	prometheus.MustRegister(serviceLatency)
-	prometheus.MustRegister(serviceThroughput)
+	registerCheckVecs(func(keys []string) []prometheus.Collector {
+		checkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "check_failures",
+			Help:      "Failures during checks, by reason: error or timeout.",
+		}, labelNames(serviceLabelNames, keys, "reason"))
+		checkSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "check_skips",
+			Help:      "Checks skipped because a check they depend on is failing.",
+		}, labelNames(serviceLabelNames, keys))
+		checkInterval = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "check_interval",
+			Help:      "The time until the next run of a check, in seconds. It's shorter than the configured interval while retrying a failing check.",
+		}, labelNames(serviceLabelNames, keys))
+		checkNextRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "check_next_run",
+			Help:      "When a check will run next, as a Unix timestamp, or zero if never.",
+		}, labelNames(serviceLabelNames, keys))
+		checkStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "check_state",
+			Help:      "The outcome of the latest run of a check. One for the current state, zero for the others.",
+		}, labelNames(serviceLabelNames, keys, "state"))
+
+		// In this case, reporting the ratio itself is probably
+		// right. I can't see that we'd want this weighted by number
+		// of packages rather than by host.
+		hostPacketLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "host_packet_loss",
+			Help:      "Packet loss between instance and remote host.",
+		}, labelNames(hostLabelNames, keys))
+		hostRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "host_rtt",
+			Help:      "RTT between instance and remote host.",
+		}, labelNames(hostLabelNames, keys))
+		serviceLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "service_latency",
+			Help:      "Latency between the instance and a remote service.",
+		}, labelNames(serviceLabelNames, keys))
+		serviceThroughput = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "service_throughput",
+			Help:      "Whether the instance can use a remote service.",
+		}, labelNames(serviceLabelNames, keys))
+		neighborPacketLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "neighbor_packet_loss",
+			Help:      "ARP/NDP request loss between instance and a link-layer neighbor, as a fraction.",
+		}, labelNames(hostLabelNames, keys))
+		neighborRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "neighbor_rtt",
+			Help:      "ARP/NDP RTT between instance and a link-layer neighbor.",
+		}, labelNames(hostLabelNames, keys))
+		neighborInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "neighbor_info",
+			Help:      "The link-layer address of a neighbor.",
+		}, labelNames(hostLabelNames, keys, "mac"))
+		neighborChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "neighbor_changes",
+			Help:      "How many times the link-layer address of a neighbor has changed.",
+		}, labelNames(hostLabelNames, keys))
+
+		return []prometheus.Collector{
+			checkFailures,
+			checkSkips,
+			checkInterval,
+			checkNextRun,
+			checkStatus,
+			hostPacketLoss,
+			hostRTT,
+			serviceLatency,
+			serviceThroughput,
+			neighborPacketLoss,
+			neighborRTT,
+			neighborInfo,
+			neighborChanges,
+		}
+	})
 }
 
-func startChecks(ctx context.Context, checks []ConnectivityCheck, chkr Checker) {
-	// We want data as early as possible, but avoid overlapping
-	// checks. The randomization domain depends on the number of
-	// checks and how slow they are, not the check's interval.
+// startChecks runs the checks until ctx is done. Each check runs at
+// its phase, so restarts don't shift it. If immediate, checks also
+// run once at start.
+func startChecks(ctx context.Context, checks []ConnectivityCheck, chkr Checker, immediate bool) {
+	spreadPhases(checks)
 	for _, chk := range checks {
-		go runCheck(ctx, chk, chkr, time.Duration(rand.Intn(int(10*time.Second)*len(checks))))
+		go runCheck(ctx, chk, chkr, immediate)
 	}
 }
 
+// defaultTimeoutFraction is the part of the interval a check may run
+// for, unless it has a timeout. This leaves time between runs.
+const defaultTimeoutFraction = 0.5
+
+// defaultScheduledTimeout is the timeout of checks that have a
+// schedule instead of an interval, unless they have a timeout.
+const defaultScheduledTimeout = 5 * time.Minute
+
+// errCheckTimeout is wrapped by errors of checks that ran out of time.
+var errCheckTimeout = errors.New("check timed out")
+
 // ConnectivityCheck encapsulates a single check against a host or service on a host.
 type ConnectivityCheck struct {
+	// Name identifies the check, so other checks can depend on
+	// it. It's also the name label of its metrics. It's optional.
+	Name string
+
 	Kind    ConnectivityCheckKind
 	Network string
 	Host    string
 	Service string
 
 	Interval time.Duration
+
+	// Schedule, if set, decides when the check runs, instead of
+	// Interval.
+	Schedule *cronSchedule
+
+	// Allow, if set, restricts runs to these times of day. Block
+	// prevents runs at these times of day.
+	Allow []timeWindow
+	Block []timeWindow
+
+	// Budget, if set, limits the data the check may use. The check
+	// is paused until the next period once it's exhausted.
+	Budget *dataBudget
+
+	// FailInterval is the interval of the first retry after a failed
+	// run. Further retries back off exponentially, up to Interval.
+	// If zero, failing checks keep their interval.
+	FailInterval time.Duration
+
+	// Timeout bounds how long a run of the check may take. If zero,
+	// defaultTimeoutFraction of Interval is used, or
+	// defaultScheduledTimeout for scheduled checks.
+	Timeout time.Duration
+
+	// Socket contains options for all sockets the check opens.
+	Socket SocketOptions
+
+	// Layer is what part of the path the check tests, for diagnosis.
+	Layer Layer
+
+	// DependsOn is the name of a check that must not be failing for
+	// this check to run.
+	DependsOn string
+
+	// Labels are added to all metrics of the check, by name.
+	Labels map[string]string
+
+	// spreadPhase is the phase assigned by spreadPhases, if
+	// phaseSpread is set.
+	spreadPhase time.Duration
+	phaseSpread bool
 }
 
-// A Checker is used by startChecks to do the actual checking.
+// timeout returns the timeout of a run, or zero if there is none.
+func (chk *ConnectivityCheck) timeout() time.Duration {
+	if chk.Timeout > 0 {
+		return chk.Timeout
+	}
+	if chk.Schedule != nil {
+		return defaultScheduledTimeout
+	}
+	return time.Duration(float64(chk.Interval) * defaultTimeoutFraction)
+}
+
+// hostLabels returns the label values for host metrics.
+func (chk *ConnectivityCheck) hostLabels() []string {
+	return chk.hostLabelValues(checkLabelKeys)
+}
+
+// serviceLabels returns the label values for service metrics.
+func (chk *ConnectivityCheck) serviceLabels() []string {
+	return chk.serviceLabelValues(checkLabelKeys)
+}
+
+// hostLabelValues returns the values of hostLabelNames, followed by
+// the values of the check labels keys.
+func (chk *ConnectivityCheck) hostLabelValues(keys []string) []string {
+	return append([]string{chk.Network, chk.Host, chk.Socket.Interface}, chk.labelValues(keys)...)
+}
+
+// serviceLabelValues returns the values of serviceLabelNames, followed
+// by the values of the check labels keys.
+func (chk *ConnectivityCheck) serviceLabelValues(keys []string) []string {
+	return append([]string{chk.Network, chk.Host, chk.Service, chk.Kind.String(), chk.Socket.Interface}, chk.labelValues(keys)...)
+}
+
+// A Checker is used by startChecks to do the actual checking. If
+// CheckPing or CheckTransfer fail, they may still return packet or
+// byte counts, so the data used is accounted for.
 type Checker interface {
-	CheckPing(ctx context.Context, network, host string, flood bool) (*ping.Statistics, error)
-	CheckConnect(ctx context.Context, network, host, service string) (time.Duration, error)
-	CheckTransfer(ctx context.Context, network, host, service string) (nbytes int, dur time.Duration, dialDur time.Duration, err error)
-	Resolver() netResolver
+	CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error)
+	CheckConnect(ctx context.Context, network, host, service string, so SocketOptions) (time.Duration, error)
+	CheckTransfer(ctx context.Context, network, host, service string, so SocketOptions) (*chargen2p.ThroughputInfo, error)
+	CheckNeighbor(ctx context.Context, network, host string, so SocketOptions) (*NeighborStatistics, error)
+	Resolver() targetResolver
 }
 
-func runCheck(ctx context.Context, chk ConnectivityCheck, chkr Checker, delay time.Duration) {
-	select {
-	case <-time.After(delay):
-		// continue
-	case <-ctx.Done():
-		return
-	}
+// runCheck runs the check at its phase every interval, or at the
+// times of its schedule, as allowed by its time windows. While it's
+// failing, it runs sooner, if it has a fail interval. If immediate,
+// the first run is now.
+func runCheck(ctx context.Context, chk ConnectivityCheck, chkr Checker, immediate bool) {
+	ot := newOutageTracker(&chk)
 
-	t := time.NewTicker(chk.Interval)
-	defer t.Stop()
+	at := chk.nextRun(time.Now())
+	if immediate {
+		at = time.Now()
+	}
+	var failures int
 	for {
-		log.Printf("Running check %s for %s/%s...", chk.Kind.String(), chk.Network, chk.Host)
-		if err := doCheck(ctx, &chk, chkr); err != nil {
-			checkFailures.WithLabelValues(chk.Network, chk.Host, chk.Service, chk.Kind.String()).Inc()
-			log.Printf("Failed check %s for %s/%s (ignored): %v", chk.Kind.String(), chk.Network, chk.Host, err)
+		if at.IsZero() {
+			checkNextRun.WithLabelValues(chk.serviceLabels()...).Set(0)
+			log.Printf("Check %s will not run again: its time windows block all runs.", chk.describe())
+			<-ctx.Done()
+			return
 		}
+		checkNextRun.WithLabelValues(chk.serviceLabels()...).Set(float64(at.UnixNano()) / float64(time.Second))
 
+		tm := time.NewTimer(time.Until(at))
 		select {
-		case <-t.C:
+		case <-tm.C:
 			// continue
 		case <-ctx.Done():
+			tm.Stop()
 			return
 		}
+
+		if runCheckOnce(ctx, &chk, chkr, ot) == stateFailed {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		var interval time.Duration
+		at, interval = chk.nextAttempt(time.Now(), failures)
+		checkInterval.WithLabelValues(chk.serviceLabels()...).Set(interval.Seconds())
+	}
+}
+
+// runCheckOnce runs the check, unless a check it depends on is
+// failing, and records the outcome. It waits for checkScheduler to
+// allow the check to run. It returns the state of the run, or an
+// empty string if ctx was done before it started.
+func runCheckOnce(ctx context.Context, chk *ConnectivityCheck, chkr Checker, ot *outageTracker) string {
+	start := time.Now()
+	if checkStates.failing(chk.DependsOn) {
+		checkSkips.WithLabelValues(chk.serviceLabels()...).Inc()
+		checkStates.set(chk, stateSkipped)
+		log.Printf("Skipped check %s for %s/%s: depends on failing check %s", chk.Kind.String(), chk.Network, chk.Host, chk.DependsOn)
+		recordHistory(newHistoryRecord(chk, start, 0, stateSkipped, nil, nil))
+		measurements.endRun(chk, start, false)
+		return stateSkipped
+	}
+
+	if reason := budgets.exhausted(chk, start); reason != "" {
+		setPaused(chk, reason)
+		checkStates.set(chk, statePaused)
+		log.Printf("Paused check %s for %s/%s: %s is exhausted", chk.Kind.String(), chk.Network, chk.Host, strings.ReplaceAll(reason, "_", " "))
+		recordHistory(newHistoryRecord(chk, start, 0, statePaused, nil, nil))
+		measurements.endRun(chk, start, false)
+		return statePaused
+	}
+	setPaused(chk, "")
+
+	release, err := waitForTurn(ctx, chk)
+	if err != nil {
+		// The exporter is stopping.
+		return ""
+	}
+	defer release()
+
+	log.Printf("Running check %s for %s/%s...", chk.Kind.String(), chk.Network, chk.Host)
+	start = time.Now()
+	vals, err := doTimedCheck(ctx, chk, chkr)
+	end := time.Now()
+	state := stateOK
+	if err != nil {
+		state = stateFailed
+		reason := "error"
+		if errors.Is(err, errCheckTimeout) {
+			reason = "timeout"
+		}
+		checkFailures.WithLabelValues(append(chk.serviceLabels(), reason)...).Inc()
+		log.Printf("Failed check %s for %s/%s (ignored): %v", chk.Kind.String(), chk.Network, chk.Host, err)
+	}
+	checkStates.set(chk, state)
+	recordDataUsage(chk, end, vals)
+	diagnoses.observe(chk, err)
+	ot.observe(end, err == nil)
+	recordHistory(newHistoryRecord(chk, start, end.Sub(start), state, vals, err))
+	measurements.endRun(chk, end, true)
+	return state
+}
+
+// doTimedCheck runs doCheck with the timeout of the check. If the
+// timeout passed, the error wraps errCheckTimeout.
+func doTimedCheck(ctx context.Context, chk *ConnectivityCheck, chkr Checker) (map[string]float64, error) {
+	timeout := chk.timeout()
+	if timeout <= 0 {
+		return doCheck(ctx, chk, chkr)
 	}
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	vals, err := doCheck(cctx, chk, chkr)
+	if err != nil && ctx.Err() == nil && cctx.Err() == context.DeadlineExceeded {
+		// Dialers report their own timeout errors, so this is
+		// decided by the context, not err.
+		return vals, fmt.Errorf("%w after %v: %v", errCheckTimeout, timeout, err)
+	}
+	return vals, err
 }
 
-func doCheck(ctx context.Context, chk *ConnectivityCheck, chkr Checker) error {
+// doCheck runs the check once and updates the metrics. It returns the
+// measured values, by name.
+func doCheck(ctx context.Context, chk *ConnectivityCheck, chkr Checker) (map[string]float64, error) {
 	// We resolve before the checking code so we're sure we're not
 	// measuring default resolver performance/availability.
-	addrs, err := chkr.Resolver().LookupIP(ctx, chk.Network, chk.Host)
+	addrs, err := chkr.Resolver().LookupIPAddr(ctx, chk.Network, chk.Host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	network := "ip6"
-	if addrs[0].To4() != nil {
+	if addrs[0].IP.To4() != nil {
 		network = "ip4"
 	}
+	// This includes the zone of link-local addresses.
 	host := addrs[0].String()
+	observeTarget(chk, &addrs[0], network)
 	var port string
 	if chk.Service != "" {
 		prt, err := chkr.Resolver().LookupPort(ctx, transportForNetwork(chk.Network, chk.Kind), chk.Service)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		port = strconv.FormatInt(int64(prt), 10)
 	}
 
 	switch chk.Kind {
 	case KindHostPing:
-		st, err := chkr.CheckPing(ctx, network, host, false)
+		st, err := chkr.CheckPing(ctx, network, host, false, chk.Socket)
 		if err != nil {
-			return err
+			return pingDataUsage(st), err
 		}
-		hostRTT.WithLabelValues(chk.Network, chk.Host).Set(float64(st.AvgRtt) / float64(time.Second))
+		if st.PacketsRecv == 0 {
+			return map[string]float64{"sent_bytes": echoBytes(st.PacketsSent)}, fmt.Errorf("no reply from %s", host)
+		}
+		setGauge(chk, hostRTT, chk.hostLabels(), float64(st.AvgRtt)/float64(time.Second))
+		return map[string]float64{"rtt": st.AvgRtt.Seconds(), "sent_bytes": echoBytes(st.PacketsSent), "received_bytes": echoBytes(st.PacketsRecv)}, nil
 
 	case KindHostFloodPing:
-		st, err := chkr.CheckPing(ctx, network, host, true)
+		st, err := chkr.CheckPing(ctx, network, host, true, chk.Socket)
 		if err != nil {
-			return err
+			return pingDataUsage(st), err
+		}
+		if st.PacketsRecv == 0 {
+			setGauge(chk, hostPacketLoss, chk.hostLabels(), 100)
+			return map[string]float64{"packet_loss": 1, "sent_bytes": echoBytes(st.PacketsSent)}, fmt.Errorf("no reply from %s", host)
 		}
-		hostPacketLoss.WithLabelValues(chk.Network, chk.Host).Set(st.PacketLoss)
-		hostRTT.WithLabelValues(chk.Network, chk.Host).Set(float64(st.AvgRtt) / float64(time.Second))
+		setGauge(chk, hostPacketLoss, chk.hostLabels(), st.PacketLoss)
+		setGauge(chk, hostRTT, chk.hostLabels(), float64(st.AvgRtt)/float64(time.Second))
+		return map[string]float64{"rtt": st.AvgRtt.Seconds(), "packet_loss": st.PacketLoss / 100, "sent_bytes": echoBytes(st.PacketsSent), "received_bytes": echoBytes(st.PacketsRecv)}, nil
 
 	case KindConnect:
-		dur, err := chkr.CheckConnect(ctx, network, host, port)
+		dur, err := chkr.CheckConnect(ctx, network, host, port, chk.Socket)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		serviceLatency.WithLabelValues(chk.Network, chk.Host, chk.Service, chk.Kind.String()).Set(float64(dur) / float64(time.Second))
+		setGauge(chk, serviceLatency, chk.serviceLabels(), float64(dur)/float64(time.Second))
+		return map[string]float64{"latency": dur.Seconds()}, nil
 
 	case KindTransfer:
-		nbytes, dur, connDur, err := chkr.CheckTransfer(ctx, network, host, port)
+		ti, err := chkr.CheckTransfer(ctx, network, host, port, chk.Socket)
 		if err != nil {
-			return err
+			if ti == nil {
+				return nil, err
+			}
+			return map[string]float64{"sent_bytes": float64(ti.NumWrittenBytes), "received_bytes": float64(ti.NumReadBytes)}, err
+		}
+		setGauge(chk, serviceLatency, chk.serviceLabels(), float64(ti.DialDuration)/float64(time.Second))
+		setGauge(chk, serviceThroughput, chk.serviceLabels(), float64(ti.NumReadBytes)/(float64(ti.ReadDuration)/float64(time.Second)))
+		return map[string]float64{
+			"latency":        ti.DialDuration.Seconds(),
+			"throughput":     float64(ti.NumReadBytes) / ti.ReadDuration.Seconds(),
+			"bytes":          float64(ti.NumReadBytes),
+			"sent_bytes":     float64(ti.NumWrittenBytes),
+			"received_bytes": float64(ti.NumReadBytes),
+		}, nil
+
+	case KindNeighbor:
+		st, err := chkr.CheckNeighbor(ctx, network, host, chk.Socket)
+		if err != nil {
+			return nil, err
+		}
+		if st.PacketsRecv == 0 {
+			setGauge(chk, neighborPacketLoss, chk.hostLabels(), 1)
+			return map[string]float64{"packet_loss": 1}, fmt.Errorf("no reply from neighbor %s on %s", host, st.Interface)
 		}
-		serviceLatency.WithLabelValues(chk.Network, chk.Host, chk.Service, chk.Kind.String()).Set(float64(connDur) / float64(time.Second))
-		serviceThroughput.WithLabelValues(chk.Network, chk.Host, chk.Service, chk.Kind.String()).Set(float64(nbytes) / (float64(dur) / float64(time.Second)))
+		setGauge(chk, neighborPacketLoss, chk.hostLabels(), st.PacketLoss/100)
+		setGauge(chk, neighborRTT, chk.hostLabels(), float64(st.AvgRtt)/float64(time.Second))
+		observeNeighborAddr(chk.hostLabels(), st.HardwareAddr)
+		return map[string]float64{"rtt": st.AvgRtt.Seconds(), "packet_loss": st.PacketLoss / 100}, nil
 
 	default:
-		return fmt.Errorf("unknown check kind: %v", chk.Kind)
+		return nil, fmt.Errorf("unknown check kind: %v", chk.Kind)
 	}
+}
 
-	return nil
+// pingDataUsage returns the data usage values of a failed ping, or nil
+// if there are no statistics.
+func pingDataUsage(st *ping.Statistics) map[string]float64 {
+	if st == nil {
+		return nil
+	}
+	return map[string]float64{"sent_bytes": echoBytes(st.PacketsSent), "received_bytes": echoBytes(st.PacketsRecv)}
 }
 
 type checker struct{}
 
 // CheckPing runs a few ICMP pings to the host. If "flood", it runs a few
 // hundred pings to measure packet loss with reasonable accuracy.
-func (checker) CheckPing(ctx context.Context, network, host string, flood bool) (*ping.Statistics, error) {
-	p := ping.New(host)
-	p.SetNetwork(network)
-	p.SetPrivileged(false)
+func (checker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	addr, err := net.ResolveIPAddr(network, host)
+	if err != nil {
+		return nil, err
+	}
+	network = "ip6"
+	if addr.IP.To4() != nil {
+		network = "ip4"
+	}
+
+	count, interval := 3, pingInterval
 	if flood {
-		p.Count = 200
-		p.Interval = 10 * time.Millisecond
-	} else {
-		p.Count = 3
-		p.Interval = pingInterval
+		count, interval = 200, 10*time.Millisecond
 	}
-	p.Timeout = time.Duration(p.Count*10) * p.Interval
-	p.RecordRtts = false // We only use aggregates.
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-	go func() {
-		select {
-		case <-ctx.Done():
-			p.Stop()
-		case <-stopCh:
-			// break
-		}
-	}()
-	if err := p.Run(); err != nil {
+
+	sock, err := sharedICMP.socket(network, so)
+	if err != nil {
 		return nil, err
 	}
-	return p.Statistics(), nil
+
+	return runPing(ctx, sock, addr, count, interval, time.Duration(count*10)*interval)
 }
 
 // CheckConnect performs a connection handshake and returns how long it took.
-func (checker) CheckConnect(ctx context.Context, network, host, service string) (time.Duration, error) {
+func (checker) CheckConnect(ctx context.Context, network, host, service string, so SocketOptions) (time.Duration, error) {
 	network = transportForNetwork(network, KindConnect)
-	var d net.Dialer
+	d := so.dialer()
 	start := time.Now()
-	conn, err := d.DialContext(ctx, network, host+":"+service)
+	conn, err := d.DialContext(ctx, network, net.JoinHostPort(host, service))
 	if err != nil {
 		return 0, err
 	}
@@ -219,20 +509,22 @@ func (checker) CheckConnect(ctx context.Context, network, host, service string)
 }
 
 // CheckTransfer sends and receives stream data to measure throughput.
-func (c checker) CheckTransfer(ctx context.Context, network, host, service string) (nbytes int, dur time.Duration, dialDur time.Duration, err error) {
-	return c.checkTransfer(ctx, network, host, service)
+// If it fails, only the byte counts are returned.
+func (c checker) CheckTransfer(ctx context.Context, network, host, service string, so SocketOptions) (*chargen2p.ThroughputInfo, error) {
+	d := &countingDialer{NetDialer: so.dialer()}
+	ti, err := c.checkTransfer(ctx, network, host, service, chargen2p.WithDialer(d))
+	if err != nil {
+		return &chargen2p.ThroughputInfo{NumWrittenBytes: int(d.written()), NumReadBytes: int(d.read())}, err
+	}
+	return ti, nil
 }
 
-func (checker) checkTransfer(ctx context.Context, network, host, service string, opts ...chargen2p.MeasureThroughputOpt) (nbytes int, dur time.Duration, dialDur time.Duration, err error) {
+func (checker) checkTransfer(ctx context.Context, network, host, service string, opts ...chargen2p.MeasureThroughputOpt) (*chargen2p.ThroughputInfo, error) {
 	network = transportForNetwork(network, KindTransfer)
-	ti, err := chargen2p.MeasureThroughput(ctx, network, host+":"+service, opts...)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	return ti.NumReadBytes, ti.ReadDuration, ti.DialDuration, nil
+	return chargen2p.MeasureThroughput(ctx, network, net.JoinHostPort(host, service), opts...)
 }
 
-func (checker) Resolver() netResolver {
+func (checker) Resolver() targetResolver {
 	return defaultResolver
 }
 
@@ -275,6 +567,11 @@ const (
 	// reports data transfer speeds. This requires an "echo" server on
 	// the other end.
 	KindTransfer
+
+	// KindNeighbor resolves the link-layer address of a directly
+	// connected host, using ARP or NDP. It reports RTT, loss and the
+	// address.
+	KindNeighbor
 )
 
 func parseConnectivityCheckKind(s string) (ConnectivityCheckKind, error) {
@@ -287,6 +584,8 @@ func parseConnectivityCheckKind(s string) (ConnectivityCheckKind, error) {
 		return KindConnect, nil
 	case "transfer":
 		return KindTransfer, nil
+	case "arp":
+		return KindNeighbor, nil
 	default:
 		return UnknownKind, fmt.Errorf("unknown connectivity check kind: %s", s)
 	}
@@ -304,6 +603,8 @@ func (k ConnectivityCheckKind) String() string {
 		return "connect"
 	case KindTransfer:
 		return "transfer"
+	case KindNeighbor:
+		return "arp"
 	default:
 		return fmt.Sprintf("unknown(%d)", k)
 	}
@@ -7,10 +7,12 @@ import (
 	"io"
 	"net"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/go-ping/ping"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/tommie/chargen2p"
 )
 
@@ -18,7 +20,46 @@ func TestRunCheck(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	runCheck(ctx, ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "localhost", Interval: 10 * time.Millisecond}, waitChecker{done: cancel}, 0)
+	runCheck(ctx, ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "localhost", Interval: 10 * time.Millisecond}, waitChecker{done: cancel}, false)
+}
+
+func TestRunCheckImmediate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Returns only if the check ran before its phase.
+	runCheck(ctx, ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "localhost", Interval: time.Hour}, waitChecker{done: cancel}, true)
+}
+
+func TestRunCheckFailInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The retry comes long before the next run.
+	chk := ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "127.0.0.3", Interval: time.Hour, FailInterval: time.Millisecond}
+	chkr := &retryingChecker{cancel: cancel, retries: 2}
+	runCheck(ctx, chk, chkr, true)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		t.Errorf("CheckPing calls: got %d, want %d", chkr.N, 1+chkr.retries)
+	}
+}
+
+// A retryingChecker fails pings, and calls cancel after the given
+// number of retries.
+type retryingChecker struct {
+	countingChecker
+
+	cancel  func()
+	retries int
+}
+
+func (c *retryingChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	st, err := c.countingChecker.CheckPing(ctx, network, host, flood, so)
+	if c.N > c.retries {
+		c.cancel()
+	}
+	return st, err
 }
 
 func TestDoCheck(t *testing.T) {
@@ -26,7 +67,7 @@ func TestDoCheck(t *testing.T) {
 
 	t.Run("ping", func(t *testing.T) {
 		var chkr fakeChecker
-		if err := doCheck(ctx, &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "localhost"}, &chkr); err != nil {
+		if _, err := doCheck(ctx, &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "localhost"}, &chkr); err != nil {
 			t.Fatalf("doCheck failed: %v", err)
 		}
 
@@ -37,18 +78,27 @@ func TestDoCheck(t *testing.T) {
 
 	t.Run("floodping", func(t *testing.T) {
 		var chkr fakeChecker
-		if err := doCheck(ctx, &ConnectivityCheck{Kind: KindHostFloodPing, Network: "ip", Host: "localhost"}, &chkr); err != nil {
+		chk := &ConnectivityCheck{Kind: KindHostFloodPing, Network: "ip", Host: "localhost"}
+		vals, err := doCheck(ctx, chk, &chkr)
+		if err != nil {
 			t.Fatalf("doCheck failed: %v", err)
 		}
 
 		if want := 1; chkr.NumPingCalls != want {
 			t.Errorf("NumPingCalls: got %d, want %d", chkr.NumPingCalls, want)
 		}
+		// The gauge is in percent, and the history value a fraction.
+		if got, want := testutil.ToFloat64(hostPacketLoss.WithLabelValues(chk.hostLabels()...)), 0.5; got != want {
+			t.Errorf("hostPacketLoss: got %v, want %v", got, want)
+		}
+		if got, want := vals["packet_loss"], 0.005; got != want {
+			t.Errorf("packet_loss: got %v, want %v", got, want)
+		}
 	})
 
 	t.Run("connect", func(t *testing.T) {
 		var chkr fakeChecker
-		if err := doCheck(ctx, &ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "localhost", Service: "echo"}, &chkr); err != nil {
+		if _, err := doCheck(ctx, &ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "localhost", Service: "echo"}, &chkr); err != nil {
 			t.Fatalf("doCheck failed: %v", err)
 		}
 
@@ -57,9 +107,31 @@ func TestDoCheck(t *testing.T) {
 		}
 	})
 
+	t.Run("arp", func(t *testing.T) {
+		var chkr fakeChecker
+		if _, err := doCheck(ctx, &ConnectivityCheck{Kind: KindNeighbor, Network: "ip", Host: "localhost"}, &chkr); err != nil {
+			t.Fatalf("doCheck failed: %v", err)
+		}
+
+		if want := 1; chkr.NumNeighborCalls != want {
+			t.Errorf("NumNeighborCalls: got %d, want %d", chkr.NumNeighborCalls, want)
+		}
+	})
+
+	t.Run("connectZone", func(t *testing.T) {
+		var chkr fakeChecker
+		if _, err := doCheck(ctx, &ConnectivityCheck{Kind: KindConnect, Network: "ip6", Host: "fe80::1%lo", Service: "echo"}, &chkr); err != nil {
+			t.Fatalf("doCheck failed: %v", err)
+		}
+
+		if want := "fe80::1%lo"; chkr.LastHost != want {
+			t.Errorf("LastHost: got %q, want %q", chkr.LastHost, want)
+		}
+	})
+
 	t.Run("transfer", func(t *testing.T) {
 		var chkr fakeChecker
-		if err := doCheck(ctx, &ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "localhost", Service: "echo"}, &chkr); err != nil {
+		if _, err := doCheck(ctx, &ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "localhost", Service: "echo"}, &chkr); err != nil {
 			t.Fatalf("doCheck failed: %v", err)
 		}
 
@@ -69,6 +141,87 @@ func TestDoCheck(t *testing.T) {
 	})
 }
 
+func TestDoCheckNoReply(t *testing.T) {
+	ctx := context.Background()
+
+	for _, kind := range []ConnectivityCheckKind{KindHostPing, KindHostFloodPing} {
+		t.Run(kind.String(), func(t *testing.T) {
+			chk := &ConnectivityCheck{Kind: kind, Network: "ip", Host: "127.0.0.6"}
+			vals, err := doCheck(ctx, chk, &silentChecker{})
+			if err == nil {
+				t.Fatalf("doCheck: got nil error, want error")
+			}
+
+			if got, want := vals["sent_bytes"], echoBytes(3); got != want {
+				t.Errorf("sent_bytes: got %v, want %v", got, want)
+			}
+			if hostRTT.DeleteLabelValues(chk.hostLabels()...) {
+				t.Errorf("hostRTT: got a value, want none")
+			}
+		})
+	}
+}
+
+func TestDoCheckFailedDataUsage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ping", func(t *testing.T) {
+		chk := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "127.0.0.7"}
+		vals, err := doCheck(ctx, chk, &partialChecker{})
+		if err == nil {
+			t.Fatalf("doCheck: got nil error, want error")
+		}
+		if got, want := vals["sent_bytes"], echoBytes(2); got != want {
+			t.Errorf("sent_bytes: got %v, want %v", got, want)
+		}
+		if got, want := vals["received_bytes"], echoBytes(1); got != want {
+			t.Errorf("received_bytes: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		chk := &ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "127.0.0.7", Service: "echo"}
+		vals, err := doCheck(ctx, chk, &partialChecker{})
+		if err == nil {
+			t.Fatalf("doCheck: got nil error, want error")
+		}
+		if got, want := vals, map[string]float64{"sent_bytes": 300, "received_bytes": 200}; !reflect.DeepEqual(got, want) {
+			t.Errorf("doCheck: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestDoTimedCheck(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("timeout", func(t *testing.T) {
+		chk := &ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "localhost", Service: "echo", Timeout: 10 * time.Millisecond}
+		_, err := doTimedCheck(ctx, chk, blockingChecker{})
+		if !errors.Is(err, errCheckTimeout) {
+			t.Fatalf("doTimedCheck: got %v, want %v", err, errCheckTimeout)
+		}
+	})
+
+	t.Run("defaultTimeout", func(t *testing.T) {
+		chk := &ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "localhost", Service: "echo", Interval: 20 * time.Millisecond}
+		_, err := doTimedCheck(ctx, chk, blockingChecker{})
+		if !errors.Is(err, errCheckTimeout) {
+			t.Fatalf("doTimedCheck: got %v, want %v", err, errCheckTimeout)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		chk := &ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "localhost", Service: "echo", Timeout: time.Minute}
+		_, err := doTimedCheck(ctx, chk, blockingChecker{})
+		if err == nil || errors.Is(err, errCheckTimeout) {
+			t.Fatalf("doTimedCheck: got %v, want non-timeout error", err)
+		}
+	})
+}
+
 func TestCheckPing(t *testing.T) {
 	if os.Getenv("CI") == "true" {
 		t.Skip("Ping test requires privileges CircleCI/Docker doesn't provide")
@@ -82,19 +235,45 @@ func TestCheckPing(t *testing.T) {
 		pingInterval = pi
 	}()
 
-	got, err := checker{}.CheckPing(ctx, "ip", "localhost", false)
-	if err != nil {
-		t.Fatalf("CheckPing failed: %v", err)
-	}
-
-	if got.PacketsSent == 0 {
-		t.Errorf("CheckPing PacketsSent: got %v, want >0", got.PacketsSent)
+	tsts := []struct {
+		Name       string
+		Host       string
+		SO         SocketOptions
+		Privileged bool
+	}{
+		{"default", "localhost", SocketOptions{}, false},
+		{"interface", "localhost", SocketOptions{Interface: "lo"}, false},
+		{"source", "localhost", SocketOptions{Source: net.IPv4(127, 0, 0, 1)}, false},
+		{"privileged", "127.0.0.1", SocketOptions{}, true},
+		{"privileged6", "::1", SocketOptions{}, true},
 	}
-	if got.PacketsRecv != got.PacketsSent {
-		t.Errorf("CheckPing PacketsRecv: got %v, want %v", got.PacketsRecv, got.PacketsSent)
-	}
-	if got.AvgRtt == 0 {
-		t.Errorf("CheckPing AvgRtt: got %v, want >0", got.AvgRtt)
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if tst.Privileged {
+				if os.Geteuid() != 0 {
+					t.Skip("Raw sockets require root")
+				}
+				icmpPrivileged = true
+				defer func() {
+					icmpPrivileged = false
+				}()
+			}
+
+			got, err := checker{}.CheckPing(ctx, "ip", tst.Host, false, tst.SO)
+			if err != nil {
+				t.Fatalf("CheckPing failed: %v", err)
+			}
+
+			if got.PacketsSent == 0 {
+				t.Errorf("CheckPing PacketsSent: got %v, want >0", got.PacketsSent)
+			}
+			if got.PacketsRecv != got.PacketsSent {
+				t.Errorf("CheckPing PacketsRecv: got %v, want %v", got.PacketsRecv, got.PacketsSent)
+			}
+			if got.AvgRtt == 0 {
+				t.Errorf("CheckPing AvgRtt: got %v, want >0", got.AvgRtt)
+			}
+		})
 	}
 }
 
@@ -118,7 +297,7 @@ func TestCheckConnect(t *testing.T) {
 
 	taddr := l.Addr().(*net.TCPAddr)
 
-	got, err := checker{}.CheckConnect(ctx, "ip", taddr.IP.String(), fmt.Sprint(taddr.Port))
+	got, err := checker{}.CheckConnect(ctx, "ip", taddr.IP.String(), fmt.Sprint(taddr.Port), SocketOptions{Source: taddr.IP})
 	if err != nil {
 		t.Fatalf("CheckConnect failed: %v", err)
 	}
@@ -145,19 +324,31 @@ func TestCheckTransfer(t *testing.T) {
 
 	taddr := l.Addr().(*net.TCPAddr)
 
-	got, got2, got3, err := checker{}.checkTransfer(ctx, "ip", taddr.IP.String(), fmt.Sprint(taddr.Port), chargen2p.WithTolerance(0.9))
+	got, err := checker{}.checkTransfer(ctx, "ip", taddr.IP.String(), fmt.Sprint(taddr.Port), chargen2p.WithTolerance(0.9))
 	if err != nil {
 		t.Fatalf("CheckTransfer failed: %v", err)
 	}
 
-	if got == 0 {
-		t.Errorf("CheckTransfer: got %v, want >0", got)
+	if got.NumReadBytes == 0 {
+		t.Errorf("CheckTransfer NumReadBytes: got %v, want >0", got.NumReadBytes)
+	}
+	if got.NumWrittenBytes == 0 {
+		t.Errorf("CheckTransfer NumWrittenBytes: got %v, want >0", got.NumWrittenBytes)
 	}
-	if got2 == 0 {
-		t.Errorf("CheckTransfer got2: got %v, want >0", got2)
+	if got.ReadDuration == 0 {
+		t.Errorf("CheckTransfer ReadDuration: got %v, want >0", got.ReadDuration)
 	}
-	if got3 == 0 {
-		t.Errorf("CheckTransfer got3: got %v, want >0", got3)
+	if got.DialDuration == 0 {
+		t.Errorf("CheckTransfer DialDuration: got %v, want >0", got.DialDuration)
+	}
+
+	d := &countingDialer{NetDialer: &net.Dialer{}}
+	got, err = checker{}.checkTransfer(ctx, "ip", taddr.IP.String(), fmt.Sprint(taddr.Port), chargen2p.WithTolerance(0.9), chargen2p.WithDialer(d))
+	if err != nil {
+		t.Fatalf("CheckTransfer with countingDialer failed: %v", err)
+	}
+	if d.written() < int64(got.NumWrittenBytes) || d.read() < int64(got.NumReadBytes) {
+		t.Errorf("countingDialer: got %d written, %d read, want at least %d, %d", d.written(), d.read(), got.NumWrittenBytes, got.NumReadBytes)
 	}
 }
 
@@ -188,22 +379,66 @@ type fakeChecker struct {
 	NumPingCalls     int
 	NumConnectCalls  int
 	NumTransferCalls int
+	NumNeighborCalls int
+	LastHost         string
 }
 
-func (c *fakeChecker) CheckPing(ctx context.Context, network, host string, flood bool) (*ping.Statistics, error) {
+func (c *fakeChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
 	c.NumPingCalls++
-	return &ping.Statistics{AvgRtt: 1 * time.Second, PacketLoss: 0.5}, nil
+	return &ping.Statistics{PacketsSent: 2, PacketsRecv: 2, AvgRtt: 1 * time.Second, PacketLoss: 0.5}, nil
 }
-func (c *fakeChecker) CheckConnect(ctx context.Context, network, host, service string) (time.Duration, error) {
+func (c *fakeChecker) CheckConnect(ctx context.Context, network, host, service string, so SocketOptions) (time.Duration, error) {
 	c.NumConnectCalls++
+	c.LastHost = host
 	return 2 * time.Second, nil
 }
-func (c *fakeChecker) CheckTransfer(ctx context.Context, network, host, service string) (nbytes int, dur time.Duration, dialDur time.Duration, err error) {
+func (c *fakeChecker) CheckTransfer(ctx context.Context, network, host, service string, so SocketOptions) (*chargen2p.ThroughputInfo, error) {
 	c.NumTransferCalls++
-	return 1024, 4 * time.Second, 3 * time.Second, nil
+	return &chargen2p.ThroughputInfo{NumReadBytes: 1024, NumWrittenBytes: 1024, ReadDuration: 4 * time.Second, DialDuration: 3 * time.Second}, nil
+}
+
+func (c *fakeChecker) CheckNeighbor(ctx context.Context, network, host string, so SocketOptions) (*NeighborStatistics, error) {
+	c.NumNeighborCalls++
+	return &NeighborStatistics{Statistics: ping.Statistics{PacketsSent: 1, PacketsRecv: 1, AvgRtt: 1 * time.Millisecond}, HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}, Interface: "eth0"}, nil
+}
+
+func (*fakeChecker) Resolver() targetResolver {
+	return defaultResolver
+}
+
+// silentChecker gets no ping replies.
+type silentChecker struct {
+	fakeChecker
+}
+
+func (c *silentChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	return &ping.Statistics{PacketsSent: 3, PacketLoss: 100}, nil
+}
+
+// partialChecker fails after using some data.
+type partialChecker struct {
+	fakeChecker
+}
+
+func (c *partialChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	return &ping.Statistics{PacketsSent: 2, PacketsRecv: 1}, context.DeadlineExceeded
+}
+
+func (c *partialChecker) CheckTransfer(ctx context.Context, network, host, service string, so SocketOptions) (*chargen2p.ThroughputInfo, error) {
+	return &chargen2p.ThroughputInfo{NumWrittenBytes: 300, NumReadBytes: 200}, errors.New("mocked failure")
+}
+
+// A blockingChecker connects until the context is done.
+type blockingChecker struct {
+	Checker
+}
+
+func (blockingChecker) CheckConnect(ctx context.Context, network, host, service string, so SocketOptions) (time.Duration, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
 }
 
-func (*fakeChecker) Resolver() netResolver {
+func (blockingChecker) Resolver() targetResolver {
 	return defaultResolver
 }
 
@@ -213,11 +448,11 @@ type waitChecker struct {
 	done func()
 }
 
-func (c waitChecker) CheckPing(ctx context.Context, network, host string, flood bool) (*ping.Statistics, error) {
+func (c waitChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
 	c.done()
 	return &ping.Statistics{}, nil
 }
 
-func (waitChecker) Resolver() netResolver {
+func (waitChecker) Resolver() targetResolver {
 	return defaultResolver
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultCommandTimeout is the timeout of a run in the check
+// subcommand, if the check has neither a timeout nor an interval.
+const defaultCommandTimeout = 10 * time.Second
+
+// errChecksFailed is returned by runCheckCommand if any check run
+// failed.
+var errChecksFailed = errors.New("some checks failed")
+
+// runCheckCommand implements the check subcommand. It runs the checks
+// once, or -count times, in order, and prints the results. Unlike
+// -check flags of the exporter, no interval is needed. If detect is
+// not nil, it selects the ICMP socket type like the exporter, and
+// checks that lack capabilities fail.
+func runCheckCommand(ctx context.Context, args []string, w io.Writer, chkr Checker, detect func() *capabilities) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	var checks []ConnectivityCheck
+	fs.Func("check", "Add a check to perform, in the format 'kind=X,af=Y,host=Z,service=W'. The interval only sets the default timeout.", func(s string) error {
+		cc, err := parseConnectivityCheck(s)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, cc)
+		return nil
+	})
+	count := fs.Int("count", 1, "How many times to run each check.")
+	interval := fs.Duration("interval", time.Second, "How long to wait between rounds, if -count is more than one.")
+	format := fs.String("format", "table", "Output format. One of table and json.")
+	privileged := fs.Bool("icmp-privileged", false, "Use raw ICMP sockets for ping checks. Requires CAP_NET_RAW.")
+	timeout := fs.Duration("timeout", defaultCommandTimeout, "How long a run may take, for checks without a timeout or interval. Zero means no limit.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(checks) == 0 {
+		return fmt.Errorf("no -check flags provided")
+	}
+	if *count < 1 {
+		return fmt.Errorf("-count must be at least one")
+	}
+	if *timeout < 0 {
+		return fmt.Errorf("-timeout must not be negative")
+	}
+	checks, err := expandAllGateways(ctx, checks, chkr.Resolver())
+	if err != nil {
+		return err
+	}
+	for i := range checks {
+		if checks[i].timeout() == 0 {
+			checks[i].Timeout = *timeout
+		}
+	}
+	if err := validateDependencies(checks); err != nil {
+		return err
+	}
+
+	// Why each check can't run, if it can't.
+	disabled := make([]string, len(checks))
+	if detect != nil {
+		icmpPrivileged = *privileged
+		caps := detect()
+		selectICMPMode(caps)
+		for i := range checks {
+			disabled[i] = missingCapability(caps, &checks[i])
+		}
+	}
+
+	var write func(io.Writer, []*historyRecord) error
+	switch *format {
+	case "table":
+		write = writeCheckTable
+	case "json":
+		write = writeCheckJSON
+	default:
+		return fmt.Errorf("unknown check output format: %s", *format)
+	}
+
+	var recs []*historyRecord
+	failed := false
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(*interval):
+				// continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// The states of named checks in this round, for depends_on.
+		states := map[string]string{}
+		for j := range checks {
+			chk := &checks[j]
+
+			start := time.Now()
+			var rec *historyRecord
+			if st := states[chk.DependsOn]; st == stateFailed || st == stateSkipped {
+				rec = newHistoryRecord(chk, start, 0, stateSkipped, nil, fmt.Errorf("depends on failing check %s", chk.DependsOn))
+			} else if disabled[j] != "" {
+				rec = newHistoryRecord(chk, start, 0, stateFailed, nil, fmt.Errorf("disabled: %s", disabled[j]))
+			} else {
+				vals, err := doTimedCheck(ctx, chk, chkr)
+				state := stateOK
+				if err != nil {
+					state = stateFailed
+				}
+				rec = newHistoryRecord(chk, start, time.Since(start), state, vals, err)
+			}
+			if chk.Name != "" {
+				states[chk.Name] = rec.State
+			}
+			if rec.State != stateOK {
+				failed = true
+			}
+			recs = append(recs, rec)
+		}
+	}
+
+	if err := write(w, recs); err != nil {
+		return err
+	}
+	if failed {
+		return errChecksFailed
+	}
+	return nil
+}
+
+func writeCheckTable(w io.Writer, recs []*historyRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATE\tDURATION\tVALUES\tERROR")
+	for _, rec := range recs {
+		dur := time.Duration(rec.Duration * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\t%s\n", reportCheckName(rec), rec.State, dur, formatCheckValues(rec.Values), rec.Error)
+	}
+	return tw.Flush()
+}
+
+// formatCheckValues formats values as sorted key=value pairs, with
+// latencies in milliseconds and throughput in Mbit/s.
+func formatCheckValues(vals map[string]float64) string {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ss := make([]string, len(keys))
+	for i, k := range keys {
+		v := vals[k]
+		var s string
+		switch k {
+		case "rtt", "latency":
+			s = formatLatency(v)
+		case "throughput":
+			s = formatThroughput(v)
+		default:
+			s = strconv.FormatFloat(v, 'g', 4, 64)
+		}
+		ss[i] = k + "=" + strings.ReplaceAll(s, " ", "")
+	}
+	return strings.Join(ss, " ")
+}
+
+func writeCheckJSON(w io.Writer, recs []*historyRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(recs)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunCheckCommand(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		var chkr fakeChecker
+		if err := runCheckCommand(ctx, []string{"-check", "kind=ping,host=localhost", "-check", "kind=connect,host=localhost,service=echo", "-count", "2", "-interval", "0"}, &buf, &chkr, nil); err != nil {
+			t.Fatalf("runCheckCommand failed: %v", err)
+		}
+
+		if want := 2; chkr.NumPingCalls != want {
+			t.Errorf("NumPingCalls: got %d, want %d", chkr.NumPingCalls, want)
+		}
+		if got, want := strings.Count(buf.String(), " ok "), 4; got != want {
+			t.Errorf("runCheckCommand ok rows: got %d, want %d in %q", got, want, buf.String())
+		}
+		if !strings.Contains(buf.String(), "ping ip/localhost") {
+			t.Errorf("runCheckCommand: got %q, want containing %q", buf.String(), "ping ip/localhost")
+		}
+	})
+
+	t.Run("jsonFailed", func(t *testing.T) {
+		var buf bytes.Buffer
+		var chkr fakeChecker
+		err := runCheckCommand(ctx, []string{"-check", "name=a,kind=ping,host=nonexistent.invalid", "-check", "kind=ping,host=localhost,depends_on=a", "-format", "json"}, &buf, &chkr, nil)
+		if err != errChecksFailed {
+			t.Fatalf("runCheckCommand err: got %v, want %v", err, errChecksFailed)
+		}
+
+		var recs []historyRecord
+		if err := json.Unmarshal(buf.Bytes(), &recs); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if len(recs) != 2 || recs[0].State != stateFailed || recs[1].State != stateSkipped {
+			t.Errorf("runCheckCommand: got %+v, want failed and skipped", recs)
+		}
+		if chkr.NumPingCalls != 0 {
+			t.Errorf("NumPingCalls: got %d, want 0", chkr.NumPingCalls)
+		}
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		defer func() {
+			icmpPrivileged = false
+		}()
+
+		var buf bytes.Buffer
+		var chkr fakeChecker
+		err := runCheckCommand(ctx, []string{"-check", "kind=ping,host=localhost", "-check", "kind=arp,host=localhost", "-format", "json"}, &buf, &chkr, func() *capabilities {
+			return &capabilities{RawICMP: true}
+		})
+		if err != errChecksFailed {
+			t.Fatalf("runCheckCommand err: got %v, want %v", err, errChecksFailed)
+		}
+
+		var recs []historyRecord
+		if err := json.Unmarshal(buf.Bytes(), &recs); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if len(recs) != 2 || recs[0].State != stateOK || recs[1].State != stateFailed || !strings.Contains(recs[1].Error, "CAP_NET_RAW") {
+			t.Errorf("runCheckCommand: got %+v, want ok and disabled", recs)
+		}
+		if !icmpPrivileged {
+			t.Errorf("icmpPrivileged: got false, want the raw fallback")
+		}
+		if chkr.NumNeighborCalls != 0 {
+			t.Errorf("NumNeighborCalls: got %d, want 0", chkr.NumNeighborCalls)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := runCheckCommand(ctx, []string{"-check", "kind=connect,host=localhost,service=echo", "-timeout", "10ms", "-format", "json"}, &buf, blockingChecker{}, nil)
+		if err != errChecksFailed {
+			t.Fatalf("runCheckCommand err: got %v, want %v", err, errChecksFailed)
+		}
+
+		var recs []historyRecord
+		if err := json.Unmarshal(buf.Bytes(), &recs); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if len(recs) != 1 || !strings.Contains(recs[0].Error, errCheckTimeout.Error()) {
+			t.Errorf("runCheckCommand: got %+v, want a timeout", recs)
+		}
+	})
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,56 +17,189 @@ func checkSliceFlagSet(set *flag.FlagSet, name, usage string) *[]ConnectivityChe
 	var ccs []ConnectivityCheck
 
 	set.Func(name, usage, func(s string) error {
-		cc := ConnectivityCheck{
-			Network: "ip",
+		cc, err := parseScheduledCheck(s)
+		if err != nil {
+			return err
 		}
+		ccs = append(ccs, cc)
+		return nil
+	})
+
+	return &ccs
+}
+
+// parseScheduledCheck parses a check for the exporter, which requires
+// an interval or a schedule.
+func parseScheduledCheck(s string) (ConnectivityCheck, error) {
+	cc, err := parseConnectivityCheck(s)
+	if err != nil {
+		return ConnectivityCheck{}, err
+	}
+	if cc.Interval == 0 && cc.Schedule == nil {
+		return ConnectivityCheck{}, fmt.Errorf("missing interval parameter: %s", s)
+	}
+	if cc.Interval != 0 && cc.Schedule != nil {
+		return ConnectivityCheck{}, fmt.Errorf("interval and schedule are mutually exclusive: %s", s)
+	}
+	if cc.Interval != 0 && cc.FailInterval > cc.Interval {
+		return ConnectivityCheck{}, fmt.Errorf("fail_interval is longer than interval: %s", s)
+	}
+	return cc, nil
+}
+
+// commaValueKeys are the keys of the -check flag whose values can
+// contain commas.
+var commaValueKeys = map[string]bool{
+	"schedule": true,
+	"allow":    true,
+	"block":    true,
+}
+
+// parseConnectivityCheck parses a check in the format of the -check
+// flag. The interval is optional.
+func parseConnectivityCheck(s string) (ConnectivityCheck, error) {
+	cc := ConnectivityCheck{
+		Network: "ip",
+	}
 
-		ss := strings.Split(s, ",")
-		for _, kv := range ss {
-			kvs := strings.SplitN(kv, "=", 2)
-			if len(kvs) == 1 {
-				return fmt.Errorf("expected key=value[,...] in check flag, got %q", s)
-			}
-			switch kvs[0] {
-			case "kind":
-				var err error
-				cc.Kind, err = parseConnectivityCheckKind(kvs[1])
-				if err != nil {
-					return err
-				}
-			case "af":
-				cc.Network = kvs[1]
-			case "host":
-				cc.Host = kvs[1]
-			case "service":
-				cc.Service = kvs[1]
-			case "interval":
-				var err error
-				cc.Interval, err = time.ParseDuration(kvs[1])
-				if err != nil {
-					return err
-				}
-			default:
-				return fmt.Errorf("unexpected key in check flag: %v", kvs[0])
+	// Schedules and time windows can contain commas, so a segment
+	// without a key continues their value.
+	var pairs [][]string
+	for _, seg := range strings.Split(s, ",") {
+		kvs := strings.SplitN(seg, "=", 2)
+		if len(kvs) == 1 {
+			if len(pairs) == 0 || !commaValueKeys[pairs[len(pairs)-1][0]] {
+				return ConnectivityCheck{}, fmt.Errorf("expected key=value[,...] in check flag, got %q", s)
 			}
+			pairs[len(pairs)-1][1] += "," + seg
+			continue
 		}
-		if cc.Host == "" {
-			return fmt.Errorf("missing host parameter: %s", s)
-		}
-		if cc.Service == "" {
-			switch cc.Kind {
-			case KindHostPing, KindHostFloodPing:
-				// Don't need service.
-			default:
-				return fmt.Errorf("missing service parameter: %s", s)
+		pairs = append(pairs, kvs)
+	}
+
+	for _, kvs := range pairs {
+		switch kvs[0] {
+		case "name":
+			cc.Name = kvs[1]
+		case "depends_on":
+			cc.DependsOn = kvs[1]
+		case "kind":
+			var err error
+			cc.Kind, err = parseConnectivityCheckKind(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "af":
+			cc.Network = kvs[1]
+		case "host":
+			cc.Host = kvs[1]
+		case "service":
+			cc.Service = kvs[1]
+		case "interface":
+			cc.Socket.Interface = kvs[1]
+		case "source":
+			cc.Socket.Source = net.ParseIP(kvs[1])
+			if cc.Socket.Source == nil {
+				return ConnectivityCheck{}, fmt.Errorf("invalid source address in check flag: %s", kvs[1])
+			}
+		case "mark":
+			mark, err := strconv.ParseUint(kvs[1], 0, 32)
+			if err != nil {
+				return ConnectivityCheck{}, fmt.Errorf("invalid mark in check flag: %w", err)
+			}
+			cc.Socket.Mark = uint32(mark)
+		case "netns":
+			cc.Socket.Netns = kvs[1]
+		case "layer":
+			var err error
+			cc.Layer, err = parseLayer(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "interval":
+			var err error
+			cc.Interval, err = time.ParseDuration(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+			if cc.Interval <= 0 {
+				return ConnectivityCheck{}, fmt.Errorf("interval must be positive in check flag: %s", kvs[1])
 			}
+		case "schedule":
+			var err error
+			cc.Schedule, err = parseCronSchedule(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "allow":
+			var err error
+			cc.Allow, err = parseTimeWindows(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "block":
+			var err error
+			cc.Block, err = parseTimeWindows(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "budget":
+			var err error
+			cc.Budget, err = parseDataBudget(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+		case "fail_interval":
+			var err error
+			cc.FailInterval, err = time.ParseDuration(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+			if cc.FailInterval <= 0 {
+				return ConnectivityCheck{}, fmt.Errorf("fail_interval must be positive in check flag: %s", kvs[1])
+			}
+		case "timeout":
+			var err error
+			cc.Timeout, err = time.ParseDuration(kvs[1])
+			if err != nil {
+				return ConnectivityCheck{}, err
+			}
+			if cc.Timeout <= 0 {
+				return ConnectivityCheck{}, fmt.Errorf("timeout must be positive in check flag: %s", kvs[1])
+			}
+		default:
+			key := strings.TrimPrefix(kvs[0], "label.")
+			if key == kvs[0] {
+				return ConnectivityCheck{}, fmt.Errorf("unexpected key in check flag: %v", kvs[0])
+			}
+			if err := validateLabelName(key); err != nil {
+				return ConnectivityCheck{}, err
+			}
+			if _, ok := cc.Labels[key]; ok {
+				return ConnectivityCheck{}, fmt.Errorf("duplicate label in check flag: %v", key)
+			}
+			if cc.Labels == nil {
+				cc.Labels = map[string]string{}
+			}
+			cc.Labels[key] = kvs[1]
 		}
-		if cc.Interval == 0 {
-			return fmt.Errorf("missing interval parameter: %s", s)
+	}
+	if cc.Host == "" {
+		return ConnectivityCheck{}, fmt.Errorf("missing host parameter: %s", s)
+	}
+	if cc.Service == "" {
+		switch cc.Kind {
+		case KindHostPing, KindHostFloodPing, KindNeighbor:
+			// Don't need service.
+		default:
+			return ConnectivityCheck{}, fmt.Errorf("missing service parameter: %s", s)
 		}
-		ccs = append(ccs, cc)
-		return nil
-	})
-
-	return &ccs
+	}
+	if cc.Host == allGatewaysHost && cc.Name != "" {
+		return ConnectivityCheck{}, fmt.Errorf("%s checks can't have a name, since they expand into one check per gateway: %s", allGatewaysHost, s)
+	}
+	if cc.Layer == UnknownLayer {
+		cc.Layer = inferLayer(&cc)
+	}
+	return cc, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"net"
 	"reflect"
 	"strings"
 	"testing"
@@ -17,9 +18,36 @@ func TestCheckSliceFlagSet(t *testing.T) {
 		{"", ConnectivityCheck{}, `got ""`},
 		{"kind=ping", ConnectivityCheck{}, `missing host`},
 		{"kind=ping,host=a", ConnectivityCheck{}, `missing interval`},
-		{"kind=ping,host=a,interval=1m", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute}, ""},
+		{"kind=ping,host=a,interval=1m", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Layer: LayerInternet}, ""},
 		{"kind=connect,host=a,interval=1m", ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "a", Interval: 1 * time.Minute}, "missing service"},
-		{"kind=connect,host=a,service=b,interval=1m", ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "a", Service: "b", Interval: 1 * time.Minute}, ""},
+		{"kind=connect,host=a,service=b,interval=1m", ConnectivityCheck{Kind: KindConnect, Network: "ip", Host: "a", Service: "b", Interval: 1 * time.Minute, Layer: LayerService}, ""},
+		{"kind=ping,host=a,interval=1m,interface=eth0,source=192.0.2.1", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Socket: SocketOptions{Interface: "eth0", Source: net.ParseIP("192.0.2.1")}, Layer: LayerInternet}, ""},
+		{"kind=ping,host=a,interval=1m,source=b", ConnectivityCheck{}, "invalid source"},
+		{"kind=ping,host=a,interval=1m,mark=0x10,netns=blue", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Socket: SocketOptions{Mark: 16, Netns: "blue"}, Layer: LayerInternet}, ""},
+		{"kind=ping,host=a,interval=1m,mark=b", ConnectivityCheck{}, "invalid mark"},
+		{"kind=ping,host=a,interval=1m,layer=isp", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Layer: LayerISP}, ""},
+		{"kind=ping,host=a,interval=1m,layer=b", ConnectivityCheck{}, "unknown layer"},
+		{"kind=ping,host=a,interval=1m,timeout=10s", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Timeout: 10 * time.Second, Layer: LayerInternet}, ""},
+		{"kind=ping,host=a,interval=1m,fail_interval=10s", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, FailInterval: 10 * time.Second, Layer: LayerInternet}, ""},
+		{"kind=ping,host=a,interval=1m,fail_interval=2m", ConnectivityCheck{}, "longer than interval"},
+		{"kind=transfer,host=a,service=b,allow=22:00-06:00,12:00-13:00,block=01:00-02:00,interval=1h", ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "a", Service: "b", Interval: time.Hour, Allow: []timeWindow{{22 * time.Hour, 6 * time.Hour}, {12 * time.Hour, 13 * time.Hour}}, Block: []timeWindow{{1 * time.Hour, 2 * time.Hour}}, Layer: LayerService}, ""},
+		{"kind=ping,host=a,schedule=0 2,14 * * *", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Schedule: mustParseCronSchedule("0 2,14 * * *"), Layer: LayerInternet}, ""},
+		{"kind=ping,host=a,schedule=0 2,14 * * *,interval=1h", ConnectivityCheck{}, "mutually exclusive"},
+		{"kind=ping,host=a,schedule=0 2 * *", ConnectivityCheck{}, "expected five fields"},
+		{"kind=ping,host=a,allow=2-3,interval=1h", ConnectivityCheck{}, "invalid time of day"},
+		{"kind=transfer,host=a,service=b,interval=1h,budget=1GB/month", ConnectivityCheck{Kind: KindTransfer, Network: "ip", Host: "a", Service: "b", Interval: time.Hour, Budget: &dataBudget{Bytes: 1e9, Period: budgetMonthly}, Layer: LayerService}, ""},
+		{"kind=transfer,host=a,service=b,interval=1h,budget=1GB", ConnectivityCheck{}, "expected SIZE/day"},
+		{"kind=ping,host=a,interval=1m,timeout=0s", ConnectivityCheck{}, "timeout must be positive"},
+		{"kind=ping,host=a,interval=1m,intervall", ConnectivityCheck{}, "expected key=value"},
+		{"kind=ping,host=a,intervall,interval=1m", ConnectivityCheck{}, "expected key=value"},
+		{"kind=ping,host=all-gateways.internal,interval=1m,name=gw", ConnectivityCheck{}, "can't have a name"},
+		{"kind=ping,host=a,interval=0s", ConnectivityCheck{}, "interval must be positive"},
+		{"kind=ping,host=a,interval=-5s", ConnectivityCheck{}, "interval must be positive"},
+		{"kind=ping,host=a,interval=1m,label.site=hq,label.uplink=lte", ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Layer: LayerInternet, Labels: map[string]string{"site": "hq", "uplink": "lte"}}, ""},
+		{"kind=ping,host=a,interval=1m,label.host=b", ConnectivityCheck{}, "used by the exporter"},
+		{"kind=ping,host=a,interval=1m,label.a-b=c", ConnectivityCheck{}, "invalid label name"},
+		{"kind=ping,host=a,interval=1m,label.site=a,label.site=b", ConnectivityCheck{}, "duplicate label"},
+		{"name=b,kind=ping,host=a,interval=1m,depends_on=gw", ConnectivityCheck{Name: "b", Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Layer: LayerInternet, DependsOn: "gw"}, ""},
 	}
 	for _, tst := range tsts {
 		t.Run(tst.S, func(t *testing.T) {
@@ -50,8 +78,8 @@ func TestCheckSliceFlagSet(t *testing.T) {
 		}
 
 		want := []ConnectivityCheck{
-			ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute},
-			ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "b", Interval: 1 * time.Minute},
+			ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: 1 * time.Minute, Layer: LayerInternet},
+			ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "b", Interval: 1 * time.Minute, Layer: LayerInternet},
 		}
 		if !reflect.DeepEqual(*got, want) {
 			t.Errorf("Parse: got %+v, want %+v", got, want)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label names of per-check metrics, before the check labels.
+var (
+	hostLabelNames    = []string{"af", "host", "interface"}
+	serviceLabelNames = []string{"af", "host", "service", "kind", "interface"}
+)
+
+// reservedLabelNames are used by the exporter's metrics, so they
+// can't be check labels.
+var reservedLabelNames = map[string]bool{
+	"af":        true,
+	"host":      true,
+	"service":   true,
+	"kind":      true,
+	"interface": true,
+	"name":      true,
+	"state":     true,
+	"reason":    true,
+	"mac":       true,
+	"target_ip": true,
+	"family":    true,
+	"layer":     true,
+	"le":        true,
+	"quantile":  true,
+	"instance":  true,
+	"job":       true,
+}
+
+// labelNameRE matches valid Prometheus label names. Names starting
+// with "__" are reserved for Prometheus.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLabelName returns an error if the name can't be used as a
+// check label.
+func validateLabelName(name string) error {
+	switch {
+	case !labelNameRE.MatchString(name) || len(name) >= 2 && name[:2] == "__":
+		return fmt.Errorf("invalid label name: %q", name)
+	case reservedLabelNames[name]:
+		return fmt.Errorf("label name is used by the exporter: %q", name)
+	default:
+		return nil
+	}
+}
+
+// checkLabelKeys are the names of the labels added to all per-check
+// metrics: "name", if any check has one, and the label.<key> options
+// of all checks. Checks without a label get an empty value. It's set
+// by setCheckLabelKeys.
+var checkLabelKeys []string
+
+// checkLabelKeysOf returns the check label keys used by the checks,
+// sorted.
+func checkLabelKeysOf(checks []ConnectivityCheck) []string {
+	var hasName bool
+	seen := map[string]bool{}
+	var keys []string
+	for _, chk := range checks {
+		hasName = hasName || chk.Name != ""
+		for k := range chk.Labels {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	if hasName {
+		keys = append([]string{"name"}, keys...)
+	}
+	return keys
+}
+
+// labelValues returns the values of the check labels, in the order of
+// keys.
+func (chk *ConnectivityCheck) labelValues(keys []string) []string {
+	vs := make([]string, len(keys))
+	for i, k := range keys {
+		if k == "name" {
+			vs[i] = chk.Name
+		} else {
+			vs[i] = chk.Labels[k]
+		}
+	}
+	return vs
+}
+
+// labelNames returns the label names of a per-check metric: the
+// built-in names, the check label keys, and names of values specific
+// to the metric.
+func labelNames(base, keys []string, names ...string) []string {
+	ret := append([]string{}, base...)
+	ret = append(ret, keys...)
+	return append(ret, names...)
+}
+
+// A checkVecSet is a group of per-check metric vectors, which are
+// recreated when the check label keys change.
+type checkVecSet struct {
+	new        func(keys []string) []prometheus.Collector
+	collectors []prometheus.Collector
+}
+
+// checkVecs collects all per-check metric vectors. A registry never
+// forgets the label names of a metric, so the vectors are registered
+// through it instead of directly.
+var checkVecs = &checkVecCollector{}
+
+func init() {
+	prometheus.MustRegister(checkVecs)
+}
+
+// A checkVecCollector is an unchecked collector of the current
+// per-check metric vectors.
+type checkVecCollector struct {
+	mu   sync.Mutex
+	sets []*checkVecSet
+}
+
+// Describe sends no descriptors, making the collector unchecked,
+// since the label names change.
+func (c *checkVecCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *checkVecCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, vs := range c.sets {
+		for _, col := range vs.collectors {
+			col.Collect(ch)
+		}
+	}
+}
+
+// registerCheckVecs creates and registers per-check metric vectors.
+// new creates the vectors with labelNames, assigns them to their
+// variables and returns them. It's called again by setCheckLabelKeys.
+func registerCheckVecs(new func(keys []string) []prometheus.Collector) {
+	checkVecs.mu.Lock()
+	defer checkVecs.mu.Unlock()
+
+	checkVecs.sets = append(checkVecs.sets, &checkVecSet{new: new, collectors: new(checkLabelKeys)})
+}
+
+// setCheckLabelKeys sets checkLabelKeys, and replaces all per-check
+// metric vectors with ones that have the keys as labels. It must be
+// called before any check runs.
+func setCheckLabelKeys(keys []string) {
+	checkVecs.mu.Lock()
+	defer checkVecs.mu.Unlock()
+
+	checkLabelKeys = keys
+	for _, vs := range checkVecs.sets {
+		vs.collectors = vs.new(keys)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckLabelKeysOf(t *testing.T) {
+	tsts := []struct {
+		Name   string
+		Checks []ConnectivityCheck
+		Want   []string
+	}{
+		{"none", []ConnectivityCheck{{Host: "a"}}, nil},
+		{"name", []ConnectivityCheck{{Host: "a"}, {Name: "b"}}, []string{"name"}},
+		{"labels", []ConnectivityCheck{{Labels: map[string]string{"uplink": "lte", "site": "x"}}, {Name: "b", Labels: map[string]string{"site": "y"}}}, []string{"name", "site", "uplink"}},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if got := checkLabelKeysOf(tst.Checks); !reflect.DeepEqual(got, tst.Want) {
+				t.Errorf("checkLabelKeysOf: got %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestValidateLabelName(t *testing.T) {
+	for _, s := range []string{"site", "_uplink", "a1"} {
+		if err := validateLabelName(s); err != nil {
+			t.Errorf("validateLabelName(%q) failed: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "1a", "a-b", "__a", "host", "name", "state"} {
+		if err := validateLabelName(s); err == nil {
+			t.Errorf("validateLabelName(%q): got nil error, want error", s)
+		}
+	}
+}
+
+func TestSetCheckLabelKeys(t *testing.T) {
+	defer setCheckLabelKeys(nil)
+
+	chk := &ConnectivityCheck{Name: "gw", Kind: KindHostPing, Network: "ip", Host: "a", Labels: map[string]string{"site": "x"}}
+	setCheckLabelKeys([]string{"name", "site", "uplink"})
+
+	if got, want := chk.hostLabels(), []string{"ip", "a", "", "gw", "x", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hostLabels: got %v, want %v", got, want)
+	}
+
+	hostRTT.WithLabelValues(chk.hostLabels()...).Set(1)
+	checkStatus.WithLabelValues(append(chk.serviceLabels(), stateOK)...).Set(1)
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	found := 0
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "connectivity_host_rtt", "connectivity_check_state":
+			found++
+			var names []string
+			for _, lp := range mf.GetMetric()[0].GetLabel() {
+				names = append(names, lp.GetName())
+			}
+			if got := strings.Join(names, ","); !strings.Contains(got, "name,") || !strings.Contains(got, "site,") {
+				t.Errorf("%s labels: got %s, want name and site", mf.GetName(), got)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("Gather: found %d metrics, want 2", found)
+	}
+
+	if got := testutil.ToFloat64(hostRTT.WithLabelValues(chk.hostLabels()...)); got != 1 {
+		t.Errorf("hostRTT: got %v, want 1", got)
+	}
+}
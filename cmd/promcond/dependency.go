@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Values of the state label of connectivity_check_state, in addition
+// to stateOK and statePaused.
+const (
+	stateFailed  = "failed"
+	stateSkipped = "skipped"
+)
+
+// checkStateValues are the values of the state label.
+var checkStateValues = []string{stateOK, stateFailed, stateSkipped, statePaused}
+
+// A checkStateMap holds the state of the latest run of each named
+// check, so dependent checks can be skipped.
+type checkStateMap struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+// checkStates is the state of all running checks.
+var checkStates = &checkStateMap{m: map[string]string{}}
+
+// set records the state of a check run and updates the metric.
+func (cs *checkStateMap) set(chk *ConnectivityCheck, state string) {
+	for _, s := range checkStateValues {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		checkStatus.WithLabelValues(append(chk.serviceLabels(), s)...).Set(v)
+	}
+
+	if chk.Name == "" {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.m[chk.Name] = state
+}
+
+// failing returns whether the named check failed, or was skipped, in
+// its latest run. An empty name, or a check that hasn't run yet, is
+// not failing.
+func (cs *checkStateMap) failing(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	st := cs.m[name]
+	return st == stateFailed || st == stateSkipped
+}
+
+// validateDependencies returns an error if check names are
+// duplicated, or if a check depends on a missing check, or on
+// itself through a cycle.
+func validateDependencies(checks []ConnectivityCheck) error {
+	deps := map[string]string{}
+	for _, chk := range checks {
+		if chk.Name == "" {
+			continue
+		}
+		if _, ok := deps[chk.Name]; ok {
+			return fmt.Errorf("duplicate check name: %s", chk.Name)
+		}
+		deps[chk.Name] = chk.DependsOn
+	}
+
+	for _, chk := range checks {
+		if chk.DependsOn == "" {
+			continue
+		}
+		if _, ok := deps[chk.DependsOn]; !ok {
+			return fmt.Errorf("check %s for %s depends on unknown check %s", chk.Kind, chk.Host, chk.DependsOn)
+		}
+		// A path without cycles visits each name at most once.
+		name := chk.DependsOn
+		for i := 0; name != ""; i++ {
+			if i > len(deps) || name == chk.Name {
+				return fmt.Errorf("dependency cycle through check %s", chk.DependsOn)
+			}
+			name = deps[name]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ping/ping"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRunCheckDependsOn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parent := ConnectivityCheck{Name: "test-parent", Kind: KindHostPing, Network: "ip", Host: "localhost"}
+	checkStates.set(&parent, stateFailed)
+
+	chk := ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "127.0.0.2", Interval: 10 * time.Millisecond, DependsOn: "test-parent"}
+	chkr := &countingChecker{}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	runCheck(ctx, chk, chkr, true)
+
+	if chkr.N != 0 {
+		t.Errorf("CheckPing calls while parent is failing: got %d, want 0", chkr.N)
+	}
+	if got := testutil.ToFloat64(checkStatus.WithLabelValues(append(chk.serviceLabels(), stateSkipped)...)); got != 1 {
+		t.Errorf("checkStatus(skipped): got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(checkSkips.WithLabelValues(chk.serviceLabels()...)); got == 0 {
+		t.Errorf("checkSkips: got %v, want >0", got)
+	}
+
+	checkStates.set(&parent, stateOK)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	chkr.done = cancel
+	runCheck(ctx, chk, chkr, true)
+
+	if chkr.N != 1 {
+		t.Errorf("CheckPing calls while parent is ok: got %d, want 1", chkr.N)
+	}
+	if got := testutil.ToFloat64(checkStatus.WithLabelValues(append(chk.serviceLabels(), stateSkipped)...)); got != 0 {
+		t.Errorf("checkStatus(skipped): got %v, want 0", got)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tsts := []struct {
+		Name    string
+		Checks  []ConnectivityCheck
+		WantErr string
+	}{
+		{"empty", nil, ""},
+		{"ok", []ConnectivityCheck{{Name: "gw"}, {Name: "isp", DependsOn: "gw"}, {DependsOn: "isp"}}, ""},
+		{"duplicate", []ConnectivityCheck{{Name: "gw"}, {Name: "gw"}}, "duplicate check name"},
+		{"unknown", []ConnectivityCheck{{Name: "gw"}, {DependsOn: "isp"}}, "unknown check"},
+		{"self", []ConnectivityCheck{{Name: "gw", DependsOn: "gw"}}, "cycle"},
+		{"cycle", []ConnectivityCheck{{Name: "a", DependsOn: "b"}, {Name: "b", DependsOn: "a"}, {DependsOn: "a"}}, "cycle"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			err := validateDependencies(tst.Checks)
+			if tst.WantErr == "" && err != nil {
+				t.Fatalf("validateDependencies failed: %v", err)
+			} else if tst.WantErr != "" && (err == nil || !strings.Contains(err.Error(), tst.WantErr)) {
+				t.Fatalf("validateDependencies err: got %v, want containing %q", err, tst.WantErr)
+			}
+		})
+	}
+}
+
+// A countingChecker counts ping calls, and calls done, if set, on
+// each.
+type countingChecker struct {
+	Checker
+
+	N    int
+	done func()
+}
+
+func (c *countingChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	c.N++
+	if c.done != nil {
+		c.done()
+		return &ping.Statistics{}, nil
+	}
+	return nil, errors.New("mocked error")
+}
+
+func (*countingChecker) Resolver() targetResolver {
+	return defaultResolver
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	connectivityStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "connectivity",
+		Name:      "status",
+		Help:      "Whether any check of the layer succeeded in its latest run. Absent until a check of the layer has run.",
+	}, []string{"layer"})
+	connectivityState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "connectivity",
+		Name:      "state",
+		Help:      "The diagnosed connectivity state. One for the current state, zero for the others.",
+	}, []string{"state"})
+)
+
+func init() {
+	prometheus.MustRegister(connectivityStatus)
+	prometheus.MustRegister(connectivityState)
+
+	for _, s := range connectivityStates {
+		connectivityState.WithLabelValues(s).Set(0)
+	}
+	connectivityState.WithLabelValues(stateUnknown).Set(1)
+}
+
+// A Layer is what part of the path to the Internet a check tests.
+type Layer int
+
+const (
+	UnknownLayer Layer = iota
+
+	// LayerLink is the local link, e.g. ARP/NDP to a neighbor.
+	LayerLink
+
+	// LayerGateway is the default gateway.
+	LayerGateway
+
+	// LayerISP is the first hop beyond the local network.
+	LayerISP
+
+	// LayerDNS is name resolution.
+	LayerDNS
+
+	// LayerInternet is general reachability of remote hosts.
+	LayerInternet
+
+	// LayerService is a specific remote service.
+	LayerService
+)
+
+// diagnosisOrder is the order layers are blamed in, unless depends_on
+// says otherwise. A failing layer explains failures of the layers
+// after it, except that DNS and the Internet are independent siblings
+// beyond the ISP.
+var diagnosisOrder = []Layer{LayerLink, LayerGateway, LayerISP, LayerInternet, LayerDNS, LayerService}
+
+const (
+	stateOK      = "ok"
+	stateUnknown = "unknown"
+)
+
+// connectivityStates are the values of the state label.
+var connectivityStates = []string{
+	stateOK,
+	stateUnknown,
+	"link_down",
+	"gateway_down",
+	"isp_down",
+	"internet_down",
+	"dns_down",
+	"service_down",
+}
+
+func parseLayer(s string) (Layer, error) {
+	switch s {
+	case "link":
+		return LayerLink, nil
+	case "gateway":
+		return LayerGateway, nil
+	case "isp":
+		return LayerISP, nil
+	case "dns":
+		return LayerDNS, nil
+	case "internet":
+		return LayerInternet, nil
+	case "service":
+		return LayerService, nil
+	default:
+		return UnknownLayer, fmt.Errorf("unknown layer: %s", s)
+	}
+}
+
+func (l Layer) String() string {
+	switch l {
+	case UnknownLayer:
+		return "unknown"
+	case LayerLink:
+		return "link"
+	case LayerGateway:
+		return "gateway"
+	case LayerISP:
+		return "isp"
+	case LayerDNS:
+		return "dns"
+	case LayerInternet:
+		return "internet"
+	case LayerService:
+		return "service"
+	default:
+		return fmt.Sprintf("unknown(%d)", l)
+	}
+}
+
+// inferLayer guesses the layer of a check from its kind and target.
+func inferLayer(chk *ConnectivityCheck) Layer {
+	switch {
+	case chk.Kind == KindNeighbor:
+		return LayerLink
+	case chk.Host == "default-gateway.internal" || chk.Host == "default-gateway6.internal" || chk.Host == allGatewaysHost:
+		return LayerGateway
+	case chk.Host == "first-hop.internal":
+		return LayerISP
+	case chk.Host == "nameserver.internal" || chk.Service == "domain" || chk.Service == "53":
+		return LayerDNS
+	case chk.Kind == KindConnect || chk.Kind == KindTransfer:
+		return LayerService
+	default:
+		return LayerInternet
+	}
+}
+
+// A diagnosis combines the latest results of all checks into a status
+// per layer, and an overall state.
+type diagnosis struct {
+	mu      sync.Mutex
+	results map[*ConnectivityCheck]checkResult
+	state   string
+}
+
+// A checkResult is the outcome of the latest run of a check, and the
+// layer it tells about.
+type checkResult struct {
+	layer Layer
+	ok    bool
+}
+
+// diagnoses is the diagnosis of all running checks.
+var diagnoses = newDiagnosis()
+
+func newDiagnosis() *diagnosis {
+	return &diagnosis{
+		results: map[*ConnectivityCheck]checkResult{},
+		state:   stateUnknown,
+	}
+}
+
+// observe records the result of a check run and updates the
+// metrics. Checks without a layer are ignored. A check that failed to
+// resolve its target tells that DNS is down, not its own layer.
+func (d *diagnosis) observe(chk *ConnectivityCheck, err error) {
+	if chk.Layer == UnknownLayer {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := checkResult{layer: chk.Layer, ok: err == nil}
+	if errors.Is(err, errNameResolution) {
+		r.layer = LayerDNS
+	}
+	d.results[chk] = r
+
+	status := d.statusLocked()
+	for _, l := range diagnosisOrder {
+		up, ok := status[l]
+		if !ok {
+			connectivityStatus.DeleteLabelValues(l.String())
+			continue
+		}
+		v := 0.0
+		if up {
+			v = 1
+		}
+		connectivityStatus.WithLabelValues(l.String()).Set(v)
+	}
+
+	state := diagnoseState(status, d.layerDepsLocked())
+	if state != d.state {
+		log.Printf("Connectivity state changed: event=state_change old=%s new=%s", d.state, state)
+		connectivityState.WithLabelValues(d.state).Set(0)
+		connectivityState.WithLabelValues(state).Set(1)
+		d.state = state
+	}
+}
+
+// statusLocked returns whether each layer with results is up. A layer
+// is up if any of its checks succeeded.
+func (d *diagnosis) statusLocked() map[Layer]bool {
+	status := map[Layer]bool{}
+	for _, r := range d.results {
+		status[r.layer] = status[r.layer] || r.ok
+	}
+	return status
+}
+
+// layerDepsLocked returns the layers each layer depends on, from the
+// depends_on options of the checks.
+func (d *diagnosis) layerDepsLocked() map[Layer][]Layer {
+	byName := map[string]*ConnectivityCheck{}
+	for chk := range d.results {
+		if chk.Name != "" {
+			byName[chk.Name] = chk
+		}
+	}
+
+	deps := map[Layer][]Layer{}
+	for chk := range d.results {
+		if p := byName[chk.DependsOn]; p != nil && p.Layer != chk.Layer {
+			deps[chk.Layer] = append(deps[chk.Layer], p.Layer)
+		}
+	}
+	return deps
+}
+
+// diagnoseState returns the state blaming the first failing layer in
+// diagnosisOrder that doesn't depend, through deps, on another
+// failing layer. Layers without results are assumed to be up.
+func diagnoseState(status map[Layer]bool, deps map[Layer][]Layer) string {
+	if len(status) == 0 {
+		return stateUnknown
+	}
+
+	down := func(l Layer) bool {
+		up, ok := status[l]
+		return ok && !up
+	}
+	var first Layer
+	for _, l := range diagnosisOrder {
+		if !down(l) {
+			continue
+		}
+		if first == UnknownLayer {
+			first = l
+		}
+		if !dependsOnDown(l, deps, down, map[Layer]bool{}) {
+			return l.String() + "_down"
+		}
+	}
+	if first != UnknownLayer {
+		// The dependencies are a cycle of failing layers.
+		return first.String() + "_down"
+	}
+	return stateOK
+}
+
+// dependsOnDown returns whether any layer l depends on, directly or
+// indirectly, is down.
+func dependsOnDown(l Layer, deps map[Layer][]Layer, down func(Layer) bool, seen map[Layer]bool) bool {
+	seen[l] = true
+	for _, p := range deps[l] {
+		if seen[p] {
+			continue
+		}
+		if down(p) || dependsOnDown(p, deps, down, seen) {
+			return true
+		}
+	}
+	return false
+}
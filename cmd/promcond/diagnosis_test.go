@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInferLayer(t *testing.T) {
+	tsts := []struct {
+		Check ConnectivityCheck
+		Want  Layer
+	}{
+		{ConnectivityCheck{Kind: KindNeighbor, Host: "default-gateway.internal"}, LayerLink},
+		{ConnectivityCheck{Kind: KindHostPing, Host: "default-gateway6.internal"}, LayerGateway},
+		{ConnectivityCheck{Kind: KindHostPing, Host: "first-hop.internal"}, LayerISP},
+		{ConnectivityCheck{Kind: KindConnect, Host: "nameserver.internal", Service: "domain"}, LayerDNS},
+		{ConnectivityCheck{Kind: KindConnect, Host: "192.0.2.53", Service: "53"}, LayerDNS},
+		{ConnectivityCheck{Kind: KindHostPing, Host: "example.com"}, LayerInternet},
+		{ConnectivityCheck{Kind: KindTransfer, Host: "example.com", Service: "echo"}, LayerService},
+	}
+	for _, tst := range tsts {
+		if got := inferLayer(&tst.Check); got != tst.Want {
+			t.Errorf("inferLayer(%+v): got %v, want %v", tst.Check, got, tst.Want)
+		}
+	}
+}
+
+func TestDiagnoseState(t *testing.T) {
+	tsts := []struct {
+		Name   string
+		Status map[Layer]bool
+		Deps   map[Layer][]Layer
+		Want   string
+	}{
+		{"empty", map[Layer]bool{}, nil, "unknown"},
+		{"ok", map[Layer]bool{LayerGateway: true, LayerInternet: true}, nil, "ok"},
+		{"gateway", map[Layer]bool{LayerGateway: false, LayerInternet: false, LayerDNS: false}, nil, "gateway_down"},
+		{"dnsOnly", map[Layer]bool{LayerGateway: true, LayerInternet: true, LayerDNS: false}, nil, "dns_down"},
+		{"internet", map[Layer]bool{LayerISP: true, LayerInternet: false, LayerDNS: false}, nil, "internet_down"},
+		{"service", map[Layer]bool{LayerInternet: true, LayerService: false}, nil, "service_down"},
+		{"dependsOn", map[Layer]bool{LayerISP: true, LayerInternet: false, LayerDNS: false}, map[Layer][]Layer{LayerInternet: {LayerDNS}}, "dns_down"},
+		{"dependsOnIndirect", map[Layer]bool{LayerInternet: false, LayerService: true, LayerDNS: false}, map[Layer][]Layer{LayerInternet: {LayerService}, LayerService: {LayerDNS}}, "dns_down"},
+		{"dependsOnUp", map[Layer]bool{LayerInternet: false, LayerDNS: true}, map[Layer][]Layer{LayerInternet: {LayerDNS}}, "internet_down"},
+		{"cycle", map[Layer]bool{LayerInternet: false, LayerDNS: false}, map[Layer][]Layer{LayerInternet: {LayerDNS}, LayerDNS: {LayerInternet}}, "internet_down"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if got := diagnoseState(tst.Status, tst.Deps); got != tst.Want {
+				t.Errorf("diagnoseState: got %q, want %q", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestDiagnosisObserve(t *testing.T) {
+	connectivityStatus.Reset()
+
+	d := newDiagnosis()
+	gw := &ConnectivityCheck{Layer: LayerGateway}
+	dns := &ConnectivityCheck{Layer: LayerDNS}
+	inet1 := &ConnectivityCheck{Layer: LayerInternet}
+	inet2 := &ConnectivityCheck{Layer: LayerInternet}
+
+	d.observe(gw, nil)
+	d.observe(dns, errors.New("mocked error"))
+	d.observe(inet1, errors.New("mocked error"))
+	d.observe(inet2, nil)
+	d.observe(&ConnectivityCheck{}, errors.New("ignored"))
+
+	if d.state != "dns_down" {
+		t.Errorf("state: got %q, want %q", d.state, "dns_down")
+	}
+	if got := testutil.ToFloat64(connectivityState.WithLabelValues("dns_down")); got != 1 {
+		t.Errorf("connectivityState(dns_down): got %v, want 1", got)
+	}
+
+	want := `
+# HELP connectivity_status Whether any check of the layer succeeded in its latest run. Absent until a check of the layer has run.
+# TYPE connectivity_status gauge
+connectivity_status{layer="dns"} 0
+connectivity_status{layer="gateway"} 1
+connectivity_status{layer="internet"} 1
+`
+	if err := testutil.CollectAndCompare(connectivityStatus, strings.NewReader(want)); err != nil {
+		t.Errorf("CollectAndCompare failed: %v", err)
+	}
+
+	d.observe(dns, nil)
+	if d.state != "ok" {
+		t.Errorf("state: got %q, want %q", d.state, "ok")
+	}
+	if got := testutil.ToFloat64(connectivityState.WithLabelValues("dns_down")); got != 0 {
+		t.Errorf("connectivityState(dns_down): got %v, want 0", got)
+	}
+}
+
+func TestDiagnosisObserveNameResolution(t *testing.T) {
+	connectivityStatus.Reset()
+
+	d := newDiagnosis()
+	byName := &ConnectivityCheck{Layer: LayerInternet, Host: "example.com"}
+	byIP := &ConnectivityCheck{Layer: LayerInternet, Host: "192.0.2.1"}
+
+	d.observe(byName, fmt.Errorf("%w: mocked error", errNameResolution))
+	d.observe(byIP, nil)
+
+	if d.state != "dns_down" {
+		t.Errorf("state: got %q, want %q", d.state, "dns_down")
+	}
+
+	want := `
+# HELP connectivity_status Whether any check of the layer succeeded in its latest run. Absent until a check of the layer has run.
+# TYPE connectivity_status gauge
+connectivity_status{layer="dns"} 0
+connectivity_status{layer="internet"} 1
+`
+	if err := testutil.CollectAndCompare(connectivityStatus, strings.NewReader(want)); err != nil {
+		t.Errorf("CollectAndCompare failed: %v", err)
+	}
+
+	d.observe(byName, nil)
+	if d.state != "ok" {
+		t.Errorf("state: got %q, want %q", d.state, "ok")
+	}
+	if err := testutil.CollectAndCompare(connectivityStatus, strings.NewReader(`
+# HELP connectivity_status Whether any check of the layer succeeded in its latest run. Absent until a check of the layer has run.
+# TYPE connectivity_status gauge
+connectivity_status{layer="internet"} 1
+`)); err != nil {
+		t.Errorf("CollectAndCompare failed: %v", err)
+	}
+}
+
+func TestDiagnosisLayerDeps(t *testing.T) {
+	d := newDiagnosis()
+	dns := &ConnectivityCheck{Name: "dns", Layer: LayerDNS}
+	inet := &ConnectivityCheck{Layer: LayerInternet, DependsOn: "dns"}
+	svc := &ConnectivityCheck{Layer: LayerService, DependsOn: "missing"}
+
+	d.observe(dns, errors.New("mocked error"))
+	d.observe(inet, errors.New("mocked error"))
+	d.observe(svc, nil)
+
+	if d.state != "dns_down" {
+		t.Errorf("state: got %q, want %q", d.state, "dns_down")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/jackpal/gateway"
+	"github.com/miekg/dns"
+)
+
+var (
+	// resolvConfPath is the resolver configuration file. It's a
+	// test injection point.
+	resolvConfPath = "/etc/resolv.conf"
+
+	// publicIPServers are the DNS servers that answer with the
+	// address of the client for publicIPName, by address family.
+	publicIPServers = map[string]string{
+		"ip4": "208.67.222.222:53",   // resolver1.opendns.com
+		"ip6": "[2620:119:35::35]:53", // resolver1.opendns.com
+	}
+	publicIPName = "myip.opendns.com."
+
+	// firstHopProbeTargets are the destinations used to trace the
+	// first hop beyond the gateway, by address family. Any address
+	// outside the local ISP works.
+	firstHopProbeTargets = map[string]net.IP{
+		"ip4": net.IPv4(1, 1, 1, 1),
+		"ip6": net.ParseIP("2606:4700:4700::1111"),
+	}
+)
+
+// discoverDefaultGateway returns the gateway of the default route
+// with the lowest metric usable with the network. For network "ip",
+// IPv4 is preferred. Link-local gateways are qualified with the zone
+// of the route's interface. If the routing table isn't available, it
+// falls back to jackpal/gateway, which only knows IPv4.
+func discoverDefaultGateway(network string) (*net.IPAddr, error) {
+	rs, err := readDefaultRoutes()
+	if err != nil {
+		if network == "ip6" {
+			return nil, err
+		}
+		ip, err := gateway.DiscoverGateway()
+		if err != nil {
+			return nil, err
+		}
+		return &net.IPAddr{IP: ip}, nil
+	}
+
+	for _, r := range rs {
+		if networkMatchesIP(network, r.Gateway) {
+			return r.IPAddr(), nil
+		}
+	}
+	return nil, fmt.Errorf("no %s default gateway found", network)
+}
+
+// discoverAllGateways returns the gateways of all default routes,
+// without duplicates.
+func discoverAllGateways() ([]net.IPAddr, error) {
+	rs, err := readDefaultRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []net.IPAddr
+	seen := map[string]bool{}
+	for _, r := range rs {
+		addr := r.IPAddr()
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		addrs = append(addrs, *addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no default gateways found")
+	}
+	return addrs, nil
+}
+
+// networkMatchesIP returns whether the address can be used with the
+// network, one of "ip", "ip4" and "ip6".
+func networkMatchesIP(network string, ip net.IP) bool {
+	switch network {
+	case "ip4":
+		return ip.To4() != nil
+	case "ip6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// discoverNameserver returns the first nameserver listed in
+// resolv.conf. It may contain an IPv6 zone.
+func discoverNameserver() (string, error) {
+	cc, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return "", err
+	}
+	if len(cc.Servers) == 0 {
+		return "", fmt.Errorf("no nameservers in %s", resolvConfPath)
+	}
+	return cc.Servers[0], nil
+}
+
+// discoverPublicIP asks an external DNS server what address our
+// queries come from. For network "ip", IPv4 is used.
+func discoverPublicIP(ctx context.Context, network string) (net.IP, error) {
+	if network == "ip" {
+		network = "ip4"
+	}
+	server, ok := publicIPServers[network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network for public IP discovery: %s", network)
+	}
+	qtype := dns.TypeA
+	if network == "ip6" {
+		qtype = dns.TypeAAAA
+	}
+
+	var m dns.Msg
+	m.SetQuestion(publicIPName, qtype)
+	c := dns.Client{Net: transportForNetwork(network, UnknownKind)}
+	resp, _, err := c.ExchangeContext(ctx, &m, server)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("public IP query failed: %s", dns.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			return rr.A, nil
+		case *dns.AAAA:
+			return rr.AAAA, nil
+		}
+	}
+	return nil, fmt.Errorf("no address in public IP response")
+}
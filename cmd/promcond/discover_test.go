@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverDefaultGateway(t *testing.T) {
+	withRouteFiles(t, t.TempDir(),
+		"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"+
+			"eth0\t00000000\t010200C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0\n")
+
+	tsts := []struct {
+		Network string
+		Want    string
+	}{
+		{"ip", "192.0.2.1"},
+		{"ip4", "192.0.2.1"},
+		{"ip6", "fe80::1%eth0"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Network, func(t *testing.T) {
+			got, err := discoverDefaultGateway(tst.Network)
+			if err != nil {
+				t.Fatalf("discoverDefaultGateway failed: %v", err)
+			}
+			if got.String() != tst.Want {
+				t.Errorf("discoverDefaultGateway: got %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestDiscoverAllGateways(t *testing.T) {
+	withRouteFiles(t, t.TempDir(),
+		"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"+
+			"eth0\t00000000\t010200C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n",
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0\n")
+
+	got, err := discoverAllGateways()
+	if err != nil {
+		t.Fatalf("discoverAllGateways failed: %v", err)
+	}
+	if want := []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1).To4()}, {IP: net.ParseIP("fd00::1")}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverAllGateways: got %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverNameserver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("search example.com\nnameserver 192.0.2.53\nnameserver 192.0.2.54\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	rcp := resolvConfPath
+	resolvConfPath = path
+	defer func() {
+		resolvConfPath = rcp
+	}()
+
+	got, err := discoverNameserver()
+	if err != nil {
+		t.Fatalf("discoverNameserver failed: %v", err)
+	}
+	if want := "192.0.2.53"; got != want {
+		t.Errorf("discoverNameserver: got %q, want %q", got, want)
+	}
+}
+
+// withRouteFiles makes the route readers use the given table contents
+// for the duration of the test.
+func withRouteFiles(t *testing.T, dir, route4, route6 string) {
+	t.Helper()
+
+	p4 := filepath.Join(dir, "route")
+	p6 := filepath.Join(dir, "ipv6_route")
+	if err := os.WriteFile(p4, []byte(route4), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.WriteFile(p6, []byte(route6), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	pnr, pnr6 := procNetRoute, procNetIPv6Route
+	procNetRoute, procNetIPv6Route = p4, p6
+	t.Cleanup(func() {
+		procNetRoute, procNetIPv6Route = pnr, pnr6
+	})
+}
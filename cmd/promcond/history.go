@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A historyRecord is the result of one check run, as stored in the
+// history file.
+type historyRecord struct {
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name,omitempty"`
+	Kind      string    `json:"kind"`
+	Network   string    `json:"af"`
+	Host      string    `json:"host"`
+	Service   string    `json:"service,omitempty"`
+	Interface string    `json:"interface,omitempty"`
+	Layer     string    `json:"layer,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// State is one of stateOK, stateFailed, stateSkipped and
+	// statePaused.
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+
+	// Duration is how long the run took, in seconds.
+	Duration float64 `json:"duration"`
+
+	// Values are the measurements of the run, as returned by
+	// doCheck.
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// newHistoryRecord returns the record of a check run.
+func newHistoryRecord(chk *ConnectivityCheck, start time.Time, dur time.Duration, state string, vals map[string]float64, err error) *historyRecord {
+	rec := &historyRecord{
+		Time:      start.UTC(),
+		Name:      chk.Name,
+		Kind:      chk.Kind.String(),
+		Network:   chk.Network,
+		Host:      chk.Host,
+		Service:   chk.Service,
+		Interface: chk.Socket.Interface,
+		Labels:    chk.Labels,
+		State:     state,
+		Duration:  dur.Seconds(),
+	}
+	if chk.Layer != UnknownLayer {
+		rec.Layer = chk.Layer.String()
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	for k, v := range vals {
+		// JSON can't represent these.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if rec.Values == nil {
+			rec.Values = map[string]float64{}
+		}
+		rec.Values[k] = v
+	}
+	return rec
+}
+
+// key identifies the check of the record.
+func (rec *historyRecord) key() string {
+	return strings.Join(append([]string{rec.Name, rec.Kind, rec.Network, rec.Host, rec.Service, rec.Interface}, labelPairs(rec.Labels)...), "\x00")
+}
+
+// labelPairs returns the labels as key=value strings, sorted.
+func labelPairs(labels map[string]string) []string {
+	var ss []string
+	for k, v := range labels {
+		ss = append(ss, k+"="+v)
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+// historyKey returns the key of the check's history records.
+func (chk *ConnectivityCheck) historyKey() string {
+	return newHistoryRecord(chk, time.Time{}, 0, "", nil, nil).key()
+}
+
+// history is where check results are recorded. It's nil if history
+// is disabled.
+var history *historyWriter
+
+// recordHistory appends a record to the history, if enabled. Errors
+// are logged.
+func recordHistory(rec *historyRecord) {
+	if history == nil {
+		return
+	}
+	if err := history.Write(rec); err != nil {
+		log.Printf("Failed to write history: %v", err)
+	}
+}
+
+// A historyWriter appends records as line-delimited JSON to a
+// file. When the file grows beyond maxSize, it's renamed with a
+// timestamp suffix, and a new file is started. Renamed files older
+// than maxAge are removed.
+type historyWriter struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu sync.Mutex
+	// f is the current file. It's nil if a rotation failed to open
+	// the new file, and Write opens it again.
+	f    *os.File
+	size int64
+}
+
+// openHistory opens the history file for appending, creating it if
+// needed.
+func openHistory(path string, maxSize int64, maxAge time.Duration) (*historyWriter, error) {
+	w := &historyWriter{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	if err := w.prune(time.Now()); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *historyWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = fi.Size()
+	return nil
+}
+
+// Close closes the current file.
+func (w *historyWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// Write appends a record, rotating the file first if it would grow
+// too large.
+func (w *historyWriter) Write(rec *historyRecord) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(bs)) > w.maxSize {
+		if err := w.rotate(rec.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.f.Write(bs)
+	w.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and starts a new one. If it fails,
+// w.f may be nil.
+func (w *historyWriter) rotate(now time.Time) error {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(w.path, w.path+"."+now.UTC().Format(historyRotatedLayout)); err != nil {
+		// Try to keep writing to the old file.
+		if oerr := w.open(); oerr != nil {
+			return fmt.Errorf("%v (and reopening failed: %v)", err, oerr)
+		}
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.prune(now)
+}
+
+// prune removes rotated files that were last written to more than
+// maxAge ago.
+func (w *historyWriter) prune(now time.Time) error {
+	if w.maxAge <= 0 {
+		return nil
+	}
+
+	paths, err := rotatedHistoryFiles(w.path)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if now.Sub(fi.ModTime()) > w.maxAge {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// historyRotatedLayout is the time suffix of rotated history files. It
+// sorts chronologically.
+const historyRotatedLayout = "20060102T150405.000000000Z"
+
+// rotatedHistoryFiles returns the rotated files of the history file,
+// oldest first.
+func rotatedHistoryFiles(path string) ([]string, error) {
+	paths, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, p := range paths {
+		if _, err := time.Parse(historyRotatedLayout, p[len(path)+1:]); err == nil {
+			ret = append(ret, p)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// readHistory calls f for each record in the history file, and its
+// rotated files, with a time in [from, to). Records are visited in
+// the order they were written.
+func readHistory(path string, from, to time.Time, f func(*historyRecord) error) error {
+	paths, err := rotatedHistoryFiles(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	} else if len(paths) == 0 {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := readHistoryFile(p, from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readHistoryFile(path string, from, to time.Time, f func(*historyRecord) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	s := bufio.NewScanner(fd)
+	for line := 1; s.Scan(); line++ {
+		var rec historyRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			// The last line may be truncated by a crash.
+			log.Printf("Skipping malformed history record at %s:%d: %v", path, line, err)
+			continue
+		}
+		if rec.Time.Before(from) || !rec.Time.Before(to) {
+			continue
+		}
+		if err := f(&rec); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
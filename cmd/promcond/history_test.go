@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewHistoryRecord(t *testing.T) {
+	chk := &ConnectivityCheck{Name: "gw", Kind: KindTransfer, Network: "ip4", Host: "a", Service: "echo", Layer: LayerService}
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	got := newHistoryRecord(chk, start, 2*time.Second, stateFailed, map[string]float64{"latency": 0.5, "throughput": math.Inf(1)}, errors.New("mocked error"))
+	want := &historyRecord{
+		Time:     start,
+		Name:     "gw",
+		Kind:     "transfer",
+		Network:  "ip4",
+		Host:     "a",
+		Service:  "echo",
+		Layer:    "service",
+		State:    "failed",
+		Error:    "mocked error",
+		Duration: 2,
+		Values:   map[string]float64{"latency": 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newHistoryRecord: got %+v, want %+v", got, want)
+	}
+}
+
+func TestHistoryWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+
+	// An old rotated file that should be pruned.
+	old := path + "." + time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Format(historyRotatedLayout)
+	if err := os.WriteFile(old, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	w, err := openHistory(path, 300, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("openHistory failed: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Stat(old): got %v, want not exist", err)
+	}
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		rec := newHistoryRecord(&ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a"}, start.Add(time.Duration(i)*time.Minute), time.Second, stateOK, map[string]float64{"rtt": 0.01}, nil)
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	rotated, err := rotatedHistoryFiles(path)
+	if err != nil {
+		t.Fatalf("rotatedHistoryFiles failed: %v", err)
+	}
+	if len(rotated) == 0 {
+		t.Fatalf("rotatedHistoryFiles: got none, want some")
+	}
+
+	var n int
+	for _, p := range append(rotated, path) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if fi.Size() > 300 {
+			t.Errorf("Size(%s): got %d, want <= 300", p, fi.Size())
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var rec historyRecord
+			if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if want := start.Add(time.Duration(n) * time.Minute); !rec.Time.Equal(want) {
+				t.Errorf("Time: got %v, want %v", rec.Time, want)
+			}
+			n++
+		}
+		f.Close()
+	}
+	if n != 5 {
+		t.Errorf("records: got %d, want 5", n)
+	}
+}
+
+func TestHistoryWriterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	w, err := openHistory(path, 0, 0)
+	if err != nil {
+		t.Fatalf("openHistory failed: %v", err)
+	}
+	defer w.Close()
+
+	// As left by a rotation that failed to open the new file.
+	w.f.Close()
+	w.f = nil
+
+	rec := newHistoryRecord(&ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a"}, time.Now(), time.Second, stateOK, nil, nil)
+	if err := w.Write(rec); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var n int
+	if err := readHistory(path, time.Time{}, time.Now().Add(time.Hour), func(*historyRecord) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatalf("readHistory failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("records: got %d, want 1", n)
+	}
+}
@@ -0,0 +1,162 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tracePort is the first UDP port traceroute uses. Nothing should
+	// be listening on it.
+	tracePort = 33434
+
+	firstHopProbes       = 3
+	firstHopProbeTimeout = 1 * time.Second
+)
+
+// discoverFirstHop traces the router two hops away, i.e. the first
+// router beyond the default gateway. It sends UDP probes with a
+// limited TTL and reads ICMP Time Exceeded errors from the socket
+// error queue, so it doesn't require privileges. For network "ip",
+// IPv4 is used.
+func discoverFirstHop(ctx context.Context, network string) (net.IP, error) {
+	if network == "ip" {
+		network = "ip4"
+	}
+	target, ok := firstHopProbeTargets[network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network for first hop discovery: %s", network)
+	}
+
+	level, recvErrOpt, ttlOpt := unix.SOL_IP, unix.IP_RECVERR, unix.IP_TTL
+	if network == "ip6" {
+		level, recvErrOpt, ttlOpt = unix.SOL_IPV6, unix.IPV6_RECVERR, unix.IPV6_UNICAST_HOPS
+	}
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				if serr = unix.SetsockoptInt(int(fd), level, recvErrOpt, 1); serr != nil {
+					return
+				}
+				serr = unix.SetsockoptInt(int(fd), level, ttlOpt, 2)
+			}); err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	pc, err := lc.ListenPacket(ctx, transportForNetwork(network, UnknownKind), "")
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	conn := pc.(*net.UDPConn)
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < firstHopProbes; i++ {
+		if _, err := conn.WriteTo([]byte("promcond"), &net.UDPAddr{IP: target, Port: tracePort + i}); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(firstHopProbeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		ip, err := readHopError(rc)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("no response from the first hop towards %v", target)
+}
+
+// readHopError waits for an ICMP error on the socket error queue, and
+// returns the address of the router that sent it.
+func readHopError(rc syscall.RawConn) (net.IP, error) {
+	b := make([]byte, 512)
+	oob := make([]byte, 512)
+	var ip net.IP
+	var rerr error
+	err := rc.Read(func(fd uintptr) bool {
+		_, oobn, _, _, err := unix.Recvmsg(int(fd), b, oob, unix.MSG_ERRQUEUE)
+		if err == unix.EAGAIN {
+			return false
+		} else if err != nil {
+			rerr = err
+			return true
+		}
+		ip, rerr = parseHopError(oob[:oobn])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ip, rerr
+}
+
+// parseHopError extracts the offender address of an IP_RECVERR
+// control message, if it was a Time Exceeded message.
+func parseHopError(oob []byte) (net.IP, error) {
+	cms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, cm := range cms {
+		var timeExceeded uint8
+		switch {
+		case cm.Header.Level == unix.SOL_IP && cm.Header.Type == unix.IP_RECVERR:
+			timeExceeded = 11
+		case cm.Header.Level == unix.SOL_IPV6 && cm.Header.Type == unix.IPV6_RECVERR:
+			timeExceeded = 3
+		default:
+			continue
+		}
+
+		var ee unix.SockExtendedErr
+		if len(cm.Data) < int(unsafe.Sizeof(ee)) {
+			return nil, fmt.Errorf("short extended error: %d bytes", len(cm.Data))
+		}
+		ee = *(*unix.SockExtendedErr)(unsafe.Pointer(&cm.Data[0]))
+		if ee.Type != timeExceeded {
+			return nil, fmt.Errorf("reached the target before the first hop (ICMP type %d, code %d)", ee.Type, ee.Code)
+		}
+
+		// The offender is a struct sockaddr following the extended error.
+		sa := cm.Data[unsafe.Sizeof(ee):]
+		switch {
+		case len(sa) >= unix.SizeofSockaddrInet4 && ee.Origin == unix.SO_EE_ORIGIN_ICMP:
+			return net.IP(append([]byte(nil), sa[4:8]...)), nil
+		case len(sa) >= unix.SizeofSockaddrInet6 && ee.Origin == unix.SO_EE_ORIGIN_ICMP6:
+			return net.IP(append([]byte(nil), sa[8:24]...)), nil
+		default:
+			return nil, fmt.Errorf("unexpected extended error origin: %d", ee.Origin)
+		}
+	}
+	return nil, fmt.Errorf("no extended error in control message")
+}
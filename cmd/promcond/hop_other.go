@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// discoverFirstHop is only implemented for Linux.
+func discoverFirstHop(ctx context.Context, network string) (net.IP, error) {
+	return nil, fmt.Errorf("first hop discovery is not supported on this platform")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// sysClassNet is the Linux sysfs directory of network
+	// interfaces. It's a test injection point.
+	sysClassNet = "/sys/class/net"
+
+	// procNetDev is the Linux interface statistics table. It's a
+	// test injection point.
+	procNetDev = "/proc/net/dev"
+
+	// procNetWireless is the Linux wireless statistics table. It's
+	// a test injection point.
+	procNetWireless = "/proc/net/wireless"
+)
+
+var (
+	linkOperStateDesc = prometheus.NewDesc(
+		"connectivity_link_operstate",
+		"The RFC 2863 operational state of the interface. Always one.",
+		[]string{"interface", "state"}, nil)
+	linkCarrierChangesDesc = prometheus.NewDesc(
+		"connectivity_link_carrier_changes",
+		"Number of times the interface carrier has changed.",
+		[]string{"interface"}, nil)
+	linkSpeedDesc = prometheus.NewDesc(
+		"connectivity_link_speed",
+		"Nominal speed of the interface, in bytes per second.",
+		[]string{"interface"}, nil)
+	linkBytesDesc = prometheus.NewDesc(
+		"connectivity_link_bytes",
+		"Bytes received or transmitted on the interface.",
+		[]string{"interface", "direction"}, nil)
+	linkPacketsDesc = prometheus.NewDesc(
+		"connectivity_link_packets",
+		"Packets received or transmitted on the interface.",
+		[]string{"interface", "direction"}, nil)
+	linkErrorsDesc = prometheus.NewDesc(
+		"connectivity_link_errors",
+		"Receive or transmit errors on the interface.",
+		[]string{"interface", "direction"}, nil)
+	linkDropsDesc = prometheus.NewDesc(
+		"connectivity_link_drops",
+		"Packets dropped while receiving or transmitting on the interface.",
+		[]string{"interface", "direction"}, nil)
+	linkWirelessQualityDesc = prometheus.NewDesc(
+		"connectivity_link_wireless_quality",
+		"Wireless link quality, in driver-specific units.",
+		[]string{"interface"}, nil)
+	linkWirelessSignalDesc = prometheus.NewDesc(
+		"connectivity_link_wireless_signal",
+		"Wireless signal level, in dBm.",
+		[]string{"interface"}, nil)
+	linkWirelessNoiseDesc = prometheus.NewDesc(
+		"connectivity_link_wireless_noise",
+		"Wireless noise level, in dBm.",
+		[]string{"interface"}, nil)
+	linkWirelessBitrateDesc = prometheus.NewDesc(
+		"connectivity_link_wireless_bitrate",
+		"Wireless transmit bitrate, in bits per second.",
+		[]string{"interface"}, nil)
+)
+
+// A linkCollector exports statistics about the local interfaces
+// that checks use, read from sysfs and procfs at scrape time.
+type linkCollector struct {
+	interfaces func() ([]string, error)
+}
+
+// newLinkCollector returns a collector for the interfaces that the
+// checks are bound to, and the interfaces of the current default
+// routes.
+func newLinkCollector(checks []ConnectivityCheck) *linkCollector {
+	var ifnames []string
+	for _, chk := range checks {
+		if chk.Socket.Interface != "" {
+			ifnames = append(ifnames, chk.Socket.Interface)
+		}
+	}
+
+	return &linkCollector{
+		interfaces: func() ([]string, error) {
+			rs, err := readDefaultRoutes()
+			if err != nil {
+				return nil, err
+			}
+			names := append([]string(nil), ifnames...)
+			for _, r := range rs {
+				names = append(names, r.Interface)
+			}
+			return uniqueStrings(names), nil
+		},
+	}
+}
+
+func (c *linkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- linkOperStateDesc
+	ch <- linkCarrierChangesDesc
+	ch <- linkSpeedDesc
+	ch <- linkBytesDesc
+	ch <- linkPacketsDesc
+	ch <- linkErrorsDesc
+	ch <- linkDropsDesc
+	ch <- linkWirelessQualityDesc
+	ch <- linkWirelessSignalDesc
+	ch <- linkWirelessNoiseDesc
+	ch <- linkWirelessBitrateDesc
+}
+
+func (c *linkCollector) Collect(ch chan<- prometheus.Metric) {
+	ifnames, err := c.interfaces()
+	if err != nil {
+		log.Printf("Failed to list interfaces for link statistics: %v", err)
+		return
+	}
+
+	devs, err := readNetDev()
+	if err != nil {
+		log.Printf("Failed to read link statistics: %v", err)
+	}
+	wls, err := readNetWireless()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to read wireless statistics: %v", err)
+	}
+
+	for _, ifname := range ifnames {
+		if s, err := readSysClassNet(ifname, "operstate"); err == nil {
+			ch <- prometheus.MustNewConstMetric(linkOperStateDesc, prometheus.GaugeValue, 1, ifname, s)
+		}
+		if v, err := readSysClassNetInt(ifname, "carrier_changes"); err == nil {
+			ch <- prometheus.MustNewConstMetric(linkCarrierChangesDesc, prometheus.CounterValue, float64(v), ifname)
+		}
+		// The speed is in Mbit/s, and -1 (or an error) if unknown.
+		if v, err := readSysClassNetInt(ifname, "speed"); err == nil && v > 0 {
+			ch <- prometheus.MustNewConstMetric(linkSpeedDesc, prometheus.GaugeValue, float64(v)*1000*1000/8, ifname)
+		}
+
+		if dev, ok := devs[ifname]; ok {
+			for _, d := range []struct {
+				Name string
+				Stat netDevDirection
+			}{{"receive", dev.Receive}, {"transmit", dev.Transmit}} {
+				ch <- prometheus.MustNewConstMetric(linkBytesDesc, prometheus.CounterValue, float64(d.Stat.Bytes), ifname, d.Name)
+				ch <- prometheus.MustNewConstMetric(linkPacketsDesc, prometheus.CounterValue, float64(d.Stat.Packets), ifname, d.Name)
+				ch <- prometheus.MustNewConstMetric(linkErrorsDesc, prometheus.CounterValue, float64(d.Stat.Errors), ifname, d.Name)
+				ch <- prometheus.MustNewConstMetric(linkDropsDesc, prometheus.CounterValue, float64(d.Stat.Drops), ifname, d.Name)
+			}
+		}
+
+		if wl, ok := wls[ifname]; ok {
+			ch <- prometheus.MustNewConstMetric(linkWirelessQualityDesc, prometheus.GaugeValue, wl.Quality, ifname)
+			ch <- prometheus.MustNewConstMetric(linkWirelessSignalDesc, prometheus.GaugeValue, wl.Signal, ifname)
+			ch <- prometheus.MustNewConstMetric(linkWirelessNoiseDesc, prometheus.GaugeValue, wl.Noise, ifname)
+			if v, err := wirelessBitrate(ifname); err == nil {
+				ch <- prometheus.MustNewConstMetric(linkWirelessBitrateDesc, prometheus.GaugeValue, v, ifname)
+			}
+		}
+	}
+}
+
+// readSysClassNet returns the trimmed contents of an interface
+// attribute file.
+func readSysClassNet(ifname, attr string) (string, error) {
+	bs, err := os.ReadFile(filepath.Join(sysClassNet, ifname, attr))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+func readSysClassNetInt(ifname, attr string) (int64, error) {
+	s, err := readSysClassNet(ifname, attr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// netDevStats are the statistics of an interface in /proc/net/dev.
+type netDevStats struct {
+	Receive  netDevDirection
+	Transmit netDevDirection
+}
+
+type netDevDirection struct {
+	Bytes   uint64
+	Packets uint64
+	Errors  uint64
+	Drops   uint64
+}
+
+func readNetDev() (map[string]netDevStats, error) {
+	f, err := os.Open(procNetDev)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseNetDev(f)
+}
+
+// parseNetDev parses the format of /proc/net/dev.
+//
+//  Inter-|   Receive                                                |  Transmit
+//   face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+//    eth0: 6114015     577    0    0    0     0          0         0    56517     484    0    0    0     0       0          0
+func parseNetDev(r io.Reader) (map[string]netDevStats, error) {
+	m := map[string]netDevStats{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		i := strings.IndexByte(s.Text(), ':')
+		if i < 0 {
+			// A header line.
+			continue
+		}
+		ifname := strings.TrimSpace(s.Text()[:i])
+		fs := strings.Fields(s.Text()[i+1:])
+		if len(fs) < 12 {
+			return nil, fmt.Errorf("expected at least 12 fields in interface statistics, got %q", s.Text())
+		}
+
+		vs := make([]uint64, len(fs))
+		for j, f := range fs {
+			var err error
+			vs[j], err = strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		m[ifname] = netDevStats{
+			Receive:  netDevDirection{Bytes: vs[0], Packets: vs[1], Errors: vs[2], Drops: vs[3]},
+			Transmit: netDevDirection{Bytes: vs[8], Packets: vs[9], Errors: vs[10], Drops: vs[11]},
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// wirelessStats are the statistics of an interface in
+// /proc/net/wireless.
+type wirelessStats struct {
+	Quality float64
+	Signal  float64
+	Noise   float64
+}
+
+func readNetWireless() (map[string]wirelessStats, error) {
+	f, err := os.Open(procNetWireless)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseNetWireless(f)
+}
+
+// parseNetWireless parses the format of /proc/net/wireless.
+//
+//  Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+//   face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+//   wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0
+func parseNetWireless(r io.Reader) (map[string]wirelessStats, error) {
+	m := map[string]wirelessStats{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		i := strings.IndexByte(s.Text(), ':')
+		if i < 0 {
+			// A header line.
+			continue
+		}
+		ifname := strings.TrimSpace(s.Text()[:i])
+		fs := strings.Fields(s.Text()[i+1:])
+		if len(fs) < 4 {
+			return nil, fmt.Errorf("expected at least 4 fields in wireless statistics, got %q", s.Text())
+		}
+
+		// A trailing dot means the value was updated since the
+		// last read.
+		vs := make([]float64, 3)
+		for j := range vs {
+			var err error
+			vs[j], err = strconv.ParseFloat(strings.TrimSuffix(fs[j+1], "."), 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		m[ifname] = wirelessStats{Quality: vs[0], Signal: vs[1], Noise: vs[2]}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// uniqueStrings returns the sorted, unique strings.
+func uniqueStrings(ss []string) []string {
+	sort.Strings(ss)
+	var ret []string
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// siocgiwrate is the Wireless Extensions ioctl to get the bitrate,
+// from linux/wireless.h.
+const siocgiwrate = 0x8B21
+
+// iwreqParam is struct iwreq, with the iw_param member of the union.
+type iwreqParam struct {
+	Name     [unix.IFNAMSIZ]byte
+	Value    int32
+	Fixed    uint8
+	Disabled uint8
+	Flags    uint16
+	_        [8]byte // Pads the union to the size of struct iw_point.
+}
+
+// wirelessBitrate returns the current transmit bitrate of a
+// wireless interface, in bits per second.
+func wirelessBitrate(ifname string) (float64, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return 0, os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+
+	var req iwreqParam
+	copy(req.Name[:len(req.Name)-1], ifname)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), siocgiwrate, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return 0, os.NewSyscallError("ioctl", errno)
+	}
+	return float64(req.Value), nil
+}
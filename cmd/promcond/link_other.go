@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+// wirelessBitrate is only implemented for Linux.
+func wirelessBitrate(ifname string) (float64, error) {
+	return 0, fmt.Errorf("wireless bitrate is not supported on this platform")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     100       1    0    0    0     0          0         0      100       1    0    0    0     0       0          0
+ wlan0: 6114015     577    1    2    0     0          0         0    56517     484    3    4    0     0       0          0
+`
+
+const testNetWireless = `Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+ face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+ wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0
+`
+
+func TestParseNetDev(t *testing.T) {
+	got, err := parseNetDev(strings.NewReader(testNetDev))
+	if err != nil {
+		t.Fatalf("parseNetDev failed: %v", err)
+	}
+
+	want := map[string]netDevStats{
+		"lo": {
+			Receive:  netDevDirection{Bytes: 100, Packets: 1},
+			Transmit: netDevDirection{Bytes: 100, Packets: 1},
+		},
+		"wlan0": {
+			Receive:  netDevDirection{Bytes: 6114015, Packets: 577, Errors: 1, Drops: 2},
+			Transmit: netDevDirection{Bytes: 56517, Packets: 484, Errors: 3, Drops: 4},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNetDev: got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseNetWireless(t *testing.T) {
+	got, err := parseNetWireless(strings.NewReader(testNetWireless))
+	if err != nil {
+		t.Fatalf("parseNetWireless failed: %v", err)
+	}
+
+	want := map[string]wirelessStats{"wlan0": {Quality: 54, Signal: -56, Noise: -256}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNetWireless: got %+v, want %+v", got, want)
+	}
+}
+
+func TestLinkCollector(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sys/wlan0/operstate":       "up\n",
+		"sys/wlan0/carrier_changes": "7\n",
+		"sys/wlan0/speed":           "-1\n",
+		"dev":                       testNetDev,
+		"wireless":                  testNetWireless,
+	}
+	for name, s := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	scn, pnd, pnw := sysClassNet, procNetDev, procNetWireless
+	sysClassNet, procNetDev, procNetWireless = filepath.Join(dir, "sys"), filepath.Join(dir, "dev"), filepath.Join(dir, "wireless")
+	defer func() {
+		sysClassNet, procNetDev, procNetWireless = scn, pnd, pnw
+	}()
+
+	c := &linkCollector{
+		interfaces: func() ([]string, error) {
+			return []string{"wlan0"}, nil
+		},
+	}
+
+	want := `
+# HELP connectivity_link_carrier_changes Number of times the interface carrier has changed.
+# TYPE connectivity_link_carrier_changes counter
+connectivity_link_carrier_changes{interface="wlan0"} 7
+# HELP connectivity_link_drops Packets dropped while receiving or transmitting on the interface.
+# TYPE connectivity_link_drops counter
+connectivity_link_drops{direction="receive",interface="wlan0"} 2
+connectivity_link_drops{direction="transmit",interface="wlan0"} 4
+# HELP connectivity_link_operstate The RFC 2863 operational state of the interface. Always one.
+# TYPE connectivity_link_operstate gauge
+connectivity_link_operstate{interface="wlan0",state="up"} 1
+# HELP connectivity_link_wireless_signal Wireless signal level, in dBm.
+# TYPE connectivity_link_wireless_signal gauge
+connectivity_link_wireless_signal{interface="wlan0"} -56
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "connectivity_link_carrier_changes", "connectivity_link_drops", "connectivity_link_operstate", "connectivity_link_speed", "connectivity_link_wireless_signal"); err != nil {
+		t.Errorf("CollectAndCompare failed: %v", err)
+	}
+}
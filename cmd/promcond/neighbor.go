@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ping/ping"
+)
+
+var (
+	// neighborProbes is the number of ARP/NDP requests per check.
+	neighborProbes = 3
+
+	// neighborInterval sets the interval between ARP/NDP
+	// requests. It's also how long we wait for a reply. It's a test
+	// injection point.
+	neighborInterval = 1 * time.Second
+)
+
+// neighborAddrs holds the last seen link-layer address, by the
+// label values of the neighbor.
+var neighborAddrs = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// observeNeighborAddr updates the neighbor info metric, and counts
+// changes of the link-layer address.
+func observeNeighborAddr(labels []string, mac net.HardwareAddr) {
+	key := strings.Join(labels, "\x00")
+
+	neighborAddrs.Lock()
+	prev, ok := neighborAddrs.m[key]
+	neighborAddrs.m[key] = mac.String()
+	neighborAddrs.Unlock()
+
+	if ok && prev != mac.String() {
+		neighborInfo.DeleteLabelValues(append(labels, prev)...)
+		neighborChanges.WithLabelValues(labels...).Inc()
+	}
+	neighborInfo.WithLabelValues(append(labels, mac.String())...).Set(1)
+}
+
+// NeighborStatistics are the results of a link-layer check.
+type NeighborStatistics struct {
+	ping.Statistics
+
+	// HardwareAddr is the link-layer address of the neighbor, from
+	// the last reply.
+	HardwareAddr net.HardwareAddr
+
+	// Interface is the name of the interface the neighbor was found
+	// on.
+	Interface string
+}
+
+// A neighborProber sends link-layer address requests and receives
+// replies.
+type neighborProber interface {
+	Close() error
+	Send() error
+	Recv(deadline time.Time) (net.HardwareAddr, error)
+}
+
+// runNeighborProbes sends count requests, waiting up to interval for
+// each reply.
+func runNeighborProbes(ctx context.Context, p neighborProber, count int, interval time.Duration) (*NeighborStatistics, error) {
+	st := &NeighborStatistics{}
+	var rtts []time.Duration
+
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		deadline := start.Add(interval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+
+		if err := p.Send(); err != nil {
+			return nil, err
+		}
+		st.PacketsSent++
+
+		mac, err := p.Recv(deadline)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rtts = append(rtts, time.Since(start))
+		st.HardwareAddr = mac
+
+		// Keep the pace.
+		select {
+		case <-time.After(time.Until(deadline)):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	st.PacketsRecv = len(rtts)
+	fillPingStatistics(&st.Statistics, rtts)
+	return st, nil
+}
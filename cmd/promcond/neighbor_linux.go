@@ -0,0 +1,282 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// CheckNeighbor resolves the link-layer address of a host on a
+// directly connected network, using ARP for IPv4 and Neighbor
+// Discovery for IPv6. This requires CAP_NET_RAW.
+func (checker) CheckNeighbor(ctx context.Context, network, host string, so SocketOptions) (*NeighborStatistics, error) {
+	addr, err := net.ResolveIPAddr(network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var st *NeighborStatistics
+	err = inNetns(so.Netns, func() error {
+		ifi, src, err := neighborInterface(ctx, addr, so)
+		if err != nil {
+			return err
+		}
+
+		var p neighborProber
+		if addr.IP.To4() != nil {
+			p, err = newARPProber(ifi, src, addr.IP)
+		} else {
+			p, err = newNDPProber(ctx, ifi, src, addr.IP, so)
+		}
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+
+		st, err = runNeighborProbes(ctx, p, neighborProbes, neighborInterval)
+		if st != nil {
+			st.Interface = ifi.Name
+			st.IPAddr = addr
+			st.Addr = addr.String()
+		}
+		return err
+	})
+	return st, err
+}
+
+// neighborInterface finds the interface and source address used to
+// reach the address. The source address is found by letting the
+// kernel route a UDP socket.
+func neighborInterface(ctx context.Context, addr *net.IPAddr, so SocketOptions) (*net.Interface, net.IP, error) {
+	d := so.dialer()
+	conn, err := d.Dialer.DialContext(ctx, "udp", net.JoinHostPort(addr.String(), "9"))
+	if err != nil {
+		return nil, nil, err
+	}
+	src := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifname := addr.Zone
+	if ifname == "" {
+		ifname = so.Interface
+	}
+	if ifname != "" {
+		ifi, err := net.InterfaceByName(ifname)
+		return ifi, src, err
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range ifis {
+		addrs, err := ifis[i].Addrs()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(src) {
+				return &ifis[i], src, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no interface has the source address %v", src)
+}
+
+// An arpProber sends ARP requests on a packet socket.
+type arpProber struct {
+	f      *os.File
+	ifi    *net.Interface
+	src    net.IP
+	target net.IP
+}
+
+func newARPProber(ifi *net.Interface, src, target net.IP) (*arpProber, error) {
+	if len(ifi.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("interface %s doesn't have an Ethernet address", ifi.Name)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	return &arpProber{
+		f:      os.NewFile(uintptr(fd), "arp"),
+		ifi:    ifi,
+		src:    src.To4(),
+		target: target.To4(),
+	}, nil
+}
+
+func (p *arpProber) Close() error {
+	return p.f.Close()
+}
+
+func (p *arpProber) Send() error {
+	// RFC 826, for Ethernet and IPv4.
+	bs := make([]byte, 28)
+	binary.BigEndian.PutUint16(bs[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(bs[2:], unix.ETH_P_IP)
+	bs[4] = 6
+	bs[5] = 4
+	binary.BigEndian.PutUint16(bs[6:], 1) // Request
+	copy(bs[8:], p.ifi.HardwareAddr)
+	copy(bs[14:], p.src)
+	copy(bs[24:], p.target)
+
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  p.ifi.Index,
+		Halen:    6,
+	}
+	copy(sa.Addr[:], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Write(func(fd uintptr) bool {
+		serr = unix.Sendto(int(fd), bs, 0, sa)
+		return serr != unix.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+func (p *arpProber) Recv(deadline time.Time) (net.HardwareAddr, error) {
+	if err := p.f.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	bs := make([]byte, 1500)
+	for {
+		n, err := p.f.Read(bs)
+		if err != nil {
+			return nil, err
+		}
+		if n < 28 || binary.BigEndian.Uint16(bs[6:]) != 2 || !bytes.Equal(bs[14:18], p.target) {
+			// Not a reply from the target.
+			continue
+		}
+		return net.HardwareAddr(append([]byte(nil), bs[8:14]...)), nil
+	}
+}
+
+// An ndpProber sends Neighbor Solicitations on an ICMPv6 socket.
+type ndpProber struct {
+	pc     net.PacketConn
+	ifi    *net.Interface
+	target net.IP
+}
+
+func newNDPProber(ctx context.Context, ifi *net.Interface, src, target net.IP, so SocketOptions) (*ndpProber, error) {
+	lc := net.ListenConfig{Control: so.control}
+	pc, err := lc.ListenPacket(ctx, "ip6:ipv6-icmp", (&net.IPAddr{IP: src, Zone: ifi.Name}).String())
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 4861 requires a hop limit of 255 to prove the
+	// packet is from the link.
+	p6 := ipv6.NewPacketConn(pc)
+	if err := p6.SetHopLimit(255); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err := p6.SetMulticastHopLimit(255); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err := p6.SetMulticastInterface(ifi); err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	return &ndpProber{pc: pc, ifi: ifi, target: target}, nil
+}
+
+func (p *ndpProber) Close() error {
+	return p.pc.Close()
+}
+
+func (p *ndpProber) Send() error {
+	// RFC 4861, section 4.3, with a Source Link-Layer Address option.
+	body := make([]byte, 4+16, 4+16+2+len(p.ifi.HardwareAddr))
+	copy(body[4:], p.target)
+	if len(p.ifi.HardwareAddr) > 0 {
+		body = append(body, 1, byte((2+len(p.ifi.HardwareAddr)+7)/8))
+		body = append(body, p.ifi.HardwareAddr...)
+		for len(body)%8 != 4 {
+			body = append(body, 0)
+		}
+	}
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: body},
+	}
+	bs, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	// The solicited-node multicast address.
+	dst := net.IP{0xFF, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xFF, p.target[13], p.target[14], p.target[15]}
+	_, err = p.pc.WriteTo(bs, &net.IPAddr{IP: dst, Zone: p.ifi.Name})
+	return err
+}
+
+func (p *ndpProber) Recv(deadline time.Time) (net.HardwareAddr, error) {
+	if err := p.pc.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	bs := make([]byte, 1500)
+	for {
+		n, _, err := p.pc.ReadFrom(bs)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := icmp.ParseMessage(protocolIPv6ICMP, bs[:n])
+		if err != nil || msg.Type != ipv6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+		body, ok := msg.Body.(*icmp.RawBody)
+		if !ok || len(body.Data) < 4+16 || !net.IP(body.Data[4:20]).Equal(p.target) {
+			continue
+		}
+
+		// Find the Target Link-Layer Address option.
+		opts := body.Data[20:]
+		for len(opts) >= 2 && opts[1] > 0 && len(opts) >= 8*int(opts[1]) {
+			if opts[0] == 2 {
+				return net.HardwareAddr(append([]byte(nil), opts[2:2+len(p.ifi.HardwareAddr)]...)), nil
+			}
+			opts = opts[8*int(opts[1]):]
+		}
+	}
+}
+
+// htons returns v in network byte order, as the host reads it.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return nativeEndian.Uint16(b[:])
+}
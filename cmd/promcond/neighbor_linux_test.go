@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckNeighbor(t *testing.T) {
+	ns := setUpTestNetns(t)
+	ctx := context.Background()
+
+	ni := neighborInterval
+	neighborInterval = 100 * time.Millisecond
+	defer func() {
+		neighborInterval = ni
+	}()
+
+	ifi, err := net.InterfaceByName("pctest0")
+	if err != nil {
+		t.Fatalf("InterfaceByName failed: %v", err)
+	}
+
+	for _, host := range []string{"198.51.100.1", "2001:db8::1"} {
+		t.Run(host, func(t *testing.T) {
+			got, err := checker{}.CheckNeighbor(ctx, "ip", host, SocketOptions{Netns: ns})
+			if err != nil {
+				t.Fatalf("CheckNeighbor failed: %v", err)
+			}
+
+			if got.PacketsRecv != got.PacketsSent {
+				t.Errorf("CheckNeighbor PacketsRecv: got %v, want %v", got.PacketsRecv, got.PacketsSent)
+			}
+			if got.HardwareAddr.String() != ifi.HardwareAddr.String() {
+				t.Errorf("CheckNeighbor HardwareAddr: got %v, want %v", got.HardwareAddr, ifi.HardwareAddr)
+			}
+			if want := "pctest1"; got.Interface != want {
+				t.Errorf("CheckNeighbor Interface: got %q, want %q", got.Interface, want)
+			}
+		})
+	}
+}
+
+func TestHtons(t *testing.T) {
+	defer func(bo binary.ByteOrder) {
+		nativeEndian = bo
+	}(nativeEndian)
+
+	tsts := []struct {
+		Order binary.ByteOrder
+		Want  uint16
+	}{
+		{binary.LittleEndian, 0x0608},
+		{binary.BigEndian, 0x0806},
+	}
+	for _, tst := range tsts {
+		nativeEndian = tst.Order
+		if got := htons(0x0806); got != tst.Want {
+			t.Errorf("htons(0x0806) in %v: got %#04x, want %#04x", tst.Order, got, tst.Want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// CheckNeighbor is only implemented for Linux.
+func (checker) CheckNeighbor(ctx context.Context, network, host string, so SocketOptions) (*NeighborStatistics, error) {
+	return nil, fmt.Errorf("neighbor checks are not supported on this platform")
+}
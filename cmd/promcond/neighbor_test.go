@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveNeighborAddr(t *testing.T) {
+	labels := []string{"ip4", "neighbortest", ""}
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+
+	observeNeighborAddr(labels, mac1)
+	observeNeighborAddr(labels, mac1)
+	if got := testutil.ToFloat64(neighborChanges.WithLabelValues(labels...)); got != 0 {
+		t.Errorf("neighborChanges: got %v, want 0", got)
+	}
+
+	observeNeighborAddr(labels, mac2)
+	if got := testutil.ToFloat64(neighborChanges.WithLabelValues(labels...)); got != 1 {
+		t.Errorf("neighborChanges: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(neighborInfo.WithLabelValues(append(labels, mac2.String())...)); got != 1 {
+		t.Errorf("neighborInfo: got %v, want 1", got)
+	}
+	if neighborInfo.DeleteLabelValues(append(labels, mac1.String())...) {
+		t.Errorf("neighborInfo: the old address was still exported")
+	}
+}
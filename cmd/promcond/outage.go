@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// outageFailureThreshold is how many consecutive failed runs
+	// start an outage.
+	outageFailureThreshold = 3
+
+	// outageRecoveryThreshold is how many consecutive successful
+	// runs end an outage.
+	outageRecoveryThreshold = 2
+
+	outages        *prometheus.CounterVec
+	outageDuration *prometheus.HistogramVec
+	outageStart    *prometheus.GaugeVec
+)
+
+func init() {
+	registerCheckVecs(func(keys []string) []prometheus.Collector {
+		outages = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "outages",
+			Help:      "Number of outages seen by a check.",
+		}, labelNames(serviceLabelNames, keys))
+		outageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "connectivity",
+			Name:      "outage_duration",
+			Help:      "Duration of ended outages, in seconds.",
+			Buckets:   []float64{10, 30, 60, 5 * 60, 15 * 60, 30 * 60, 60 * 60, 3 * 60 * 60, 12 * 60 * 60, 24 * 60 * 60},
+		}, labelNames(serviceLabelNames, keys))
+		outageStart = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "outage_start",
+			Help:      "Start of the current outage, as a Unix timestamp, or zero if there is none.",
+		}, labelNames(serviceLabelNames, keys))
+
+		return []prometheus.Collector{outages, outageDuration, outageStart}
+	})
+}
+
+// An outage is a period when a check was failing. End is zero while
+// the outage is ongoing.
+type outage struct {
+	Start time.Time
+	End   time.Time
+}
+
+// An outageDetector turns the results of a check into outages. An
+// outage starts at the first of Failures consecutive failures, and
+// ends at the first of Recoveries consecutive successes.
+type outageDetector struct {
+	Failures   int
+	Recoveries int
+
+	current *outage
+	streak  int
+	// streakStart is the time of the first run of the current
+	// streak of failures or successes.
+	streakStart time.Time
+}
+
+// observe updates the detector with the result of a run. It returns
+// the outage if it started or ended with this run, and nil
+// otherwise. An outage returned when starting gets its End set when
+// it ends.
+func (d *outageDetector) observe(now time.Time, ok bool) *outage {
+	// A success during an outage, or a failure outside one, extends
+	// the streak that may end the current state.
+	if ok == (d.current != nil) {
+		if d.streak == 0 {
+			d.streakStart = now
+		}
+		d.streak++
+	} else {
+		d.streak = 0
+	}
+
+	switch {
+	case d.current == nil && d.streak >= d.Failures:
+		d.current = &outage{Start: d.streakStart}
+		d.streak = 0
+		return d.current
+
+	case d.current != nil && d.streak >= d.Recoveries:
+		o := d.current
+		o.End = d.streakStart
+		d.current = nil
+		d.streak = 0
+		return o
+
+	default:
+		return nil
+	}
+}
+
+// An outageTracker exports metrics and logs events for the outages
+// of a running check.
+type outageTracker struct {
+	outageDetector
+
+	chk *ConnectivityCheck
+}
+
+func newOutageTracker(chk *ConnectivityCheck) *outageTracker {
+	outageStart.WithLabelValues(chk.serviceLabels()...).Set(0)
+	return &outageTracker{
+		outageDetector: outageDetector{
+			Failures:   outageFailureThreshold,
+			Recoveries: outageRecoveryThreshold,
+		},
+		chk: chk,
+	}
+}
+
+// observe updates the tracker with the result of a run.
+func (ot *outageTracker) observe(now time.Time, ok bool) {
+	o := ot.outageDetector.observe(now, ok)
+	if o == nil {
+		return
+	}
+
+	labels := ot.chk.serviceLabels()
+	if o.End.IsZero() {
+		outages.WithLabelValues(labels...).Inc()
+		outageStart.WithLabelValues(labels...).Set(float64(o.Start.UnixNano()) / float64(time.Second))
+		log.Printf("Outage started: event=outage_start kind=%s af=%s host=%s service=%s start=%s",
+			ot.chk.Kind, ot.chk.Network, ot.chk.Host, ot.chk.Service, o.Start.UTC().Format(time.RFC3339))
+	} else {
+		outageDuration.WithLabelValues(labels...).Observe(o.End.Sub(o.Start).Seconds())
+		outageStart.WithLabelValues(labels...).Set(0)
+		log.Printf("Outage ended: event=outage_end kind=%s af=%s host=%s service=%s start=%s end=%s duration=%v",
+			ot.chk.Kind, ot.chk.Network, ot.chk.Host, ot.chk.Service, o.Start.UTC().Format(time.RFC3339), o.End.UTC().Format(time.RFC3339), o.End.Sub(o.Start))
+	}
+}
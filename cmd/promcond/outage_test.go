@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestOutageTracker(t *testing.T) {
+	chk := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "outage.example.com"}
+	labels := chk.serviceLabels()
+	ot := newOutageTracker(chk)
+
+	t0 := time.Unix(1000, 0)
+	for i, ok := range []bool{true, false, false, true, false, false, false, false, true, false, true, true, true} {
+		ot.observe(t0.Add(time.Duration(i)*time.Minute), ok)
+
+		switch i {
+		case 5:
+			if ot.current != nil {
+				t.Errorf("inOutage after two failures: got true, want false")
+			}
+		case 6:
+			if got, want := testutil.ToFloat64(outageStart.WithLabelValues(labels...)), float64(t0.Add(4*time.Minute).Unix()); got != want {
+				t.Errorf("outageStart: got %v, want %v", got, want)
+			}
+		}
+	}
+
+	if ot.current != nil {
+		t.Errorf("inOutage: got true, want false")
+	}
+	if got := testutil.ToFloat64(outages.WithLabelValues(labels...)); got != 1 {
+		t.Errorf("outages: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(outageStart.WithLabelValues(labels...)); got != 0 {
+		t.Errorf("outageStart: got %v, want 0", got)
+	}
+	// The outage ran from the failure at 4 to the success at 10.
+	var m dto.Metric
+	if err := outageDuration.WithLabelValues(labels...).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got, want := m.GetHistogram().GetSampleSum(), (6 * time.Minute).Seconds(); got != want {
+		t.Errorf("outageDuration sum: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-ping/ping"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Protocol numbers for icmp.ParseMessage.
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// pingPayloadSize is the size of the echo request payload. It's the
+// same as the default of ping(8).
+const pingPayloadSize = 56
+
+// An icmpEngine shares ICMP sockets between ping checks. There is
+// one socket per address family and socket options. Replies are
+// demultiplexed by sequence number, and the identifier for raw
+// sockets. The kernel chooses the identifier of datagram sockets.
+type icmpEngine struct {
+	mu    sync.Mutex
+	socks map[icmpSocketKey]*icmpSocket
+	// closed is whether Close was called. No sockets are opened
+	// after it.
+	closed bool
+}
+
+// sharedICMP is the engine used by ping checks.
+var sharedICMP = newICMPEngine()
+
+func newICMPEngine() *icmpEngine {
+	return &icmpEngine{socks: map[icmpSocketKey]*icmpSocket{}}
+}
+
+// An icmpSocketKey identifies a shared socket.
+type icmpSocketKey struct {
+	Network    string
+	Interface  string
+	Source     string
+	Mark       uint32
+	Netns      string
+	Privileged bool
+}
+
+// socket returns the shared socket for the network, one of "ip4" and
+// "ip6", and options, opening it if needed.
+func (e *icmpEngine) socket(network string, so SocketOptions) (*icmpSocket, error) {
+	key := icmpSocketKey{
+		Network:    network,
+		Interface:  so.Interface,
+		Mark:       so.Mark,
+		Netns:      so.Netns,
+		Privileged: icmpPrivileged,
+	}
+	if so.Source != nil {
+		key.Source = so.Source.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, net.ErrClosed
+	}
+	if s := e.socks[key]; s != nil {
+		return s, nil
+	}
+
+	conn, err := listenICMP(network, so)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &icmpSocket{
+		conn:    conn,
+		proto:   protocolICMP,
+		reqType: ipv4.ICMPTypeEcho,
+		id:      int(id[0])<<8 | int(id[1]),
+		pending: map[int]pendingEcho{},
+		done:    make(chan struct{}),
+	}
+	if network == "ip6" {
+		s.proto, s.reqType = protocolIPv6ICMP, ipv6.ICMPTypeEchoRequest
+	}
+	e.socks[key] = s
+
+	go func() {
+		defer close(s.done)
+
+		err := s.readReplies()
+
+		e.mu.Lock()
+		closed := e.closed
+		if e.socks[key] == s {
+			delete(e.socks, key)
+		}
+		e.mu.Unlock()
+		conn.Close()
+
+		if !closed {
+			log.Printf("Shared ICMP socket for %s failed (reopening on next use): %v", network, err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Close closes all sockets, and waits for their readers to stop.
+// Their read errors aren't logged.
+func (e *icmpEngine) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	socks := e.socks
+	e.socks = map[icmpSocketKey]*icmpSocket{}
+	e.mu.Unlock()
+
+	for _, s := range socks {
+		s.conn.Close()
+		<-s.done
+	}
+	return nil
+}
+
+// An icmpSocket is a shared ICMP socket.
+type icmpSocket struct {
+	conn    net.PacketConn
+	proto   int
+	reqType icmp.Type
+
+	// id is the echo identifier. Only raw sockets use it.
+	id int
+
+	// done is closed when the reader has stopped.
+	done chan struct{}
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]pendingEcho
+}
+
+// A pendingEcho is a sent echo request waiting for a reply.
+type pendingEcho struct {
+	Session *pingSession
+	Sent    time.Time
+}
+
+// A pingSession is a run of echo requests to one destination.
+type pingSession struct {
+	payload []byte
+	replies chan pingReply
+
+	// seqs are the sequence numbers of echo requests still pending.
+	seqs []int
+}
+
+// A pingReply is a received echo reply.
+type pingReply struct {
+	RTT time.Duration
+}
+
+// send sends an echo request for the session.
+func (s *icmpSocket) send(sess *pingSession, dst *net.IPAddr) error {
+	s.mu.Lock()
+	// Skip sequence numbers still in use, in case of wrap-around.
+	for {
+		s.seq = (s.seq + 1) & 0xFFFF
+		if _, ok := s.pending[s.seq]; !ok {
+			break
+		}
+	}
+	seq := s.seq
+	s.pending[seq] = pendingEcho{Session: sess, Sent: time.Now()}
+	sess.seqs = append(sess.seqs, seq)
+	s.mu.Unlock()
+
+	msg := icmp.Message{
+		Type: s.reqType,
+		Body: &icmp.Echo{ID: s.id, Seq: seq, Data: sess.payload},
+	}
+	bs, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteTo(bs, echoAddr(s.conn, dst))
+	return err
+}
+
+// end forgets the pending requests of the session.
+func (s *icmpSocket) end(sess *pingSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seq := range sess.seqs {
+		if pe, ok := s.pending[seq]; ok && pe.Session == sess {
+			delete(s.pending, seq)
+		}
+	}
+}
+
+// readReplies reads from the socket until it fails, and delivers
+// echo replies to their sessions. Replies are dropped if the session
+// channel is full.
+func (s *icmpSocket) readReplies() error {
+	_, raw := s.conn.LocalAddr().(*net.IPAddr)
+
+	bs := make([]byte, 1500)
+	for {
+		n, _, err := s.conn.ReadFrom(bs)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		msg, err := icmp.ParseMessage(s.proto, bs[:n])
+		if err != nil {
+			// Not ours to worry about.
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || (msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		if raw && echo.ID != s.id {
+			// A raw socket sees the replies of every process.
+			continue
+		}
+
+		s.mu.Lock()
+		pe, ok := s.pending[echo.Seq]
+		if ok && bytes.Equal(echo.Data, pe.Session.payload) {
+			delete(s.pending, echo.Seq)
+		} else {
+			ok = false
+		}
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		select {
+		case pe.Session.replies <- pingReply{RTT: now.Sub(pe.Sent)}:
+		default:
+		}
+	}
+}
+
+// runPing sends count ICMP echo requests, interval apart, on the
+// shared socket. It returns when all replies have been received, or
+// timeout has passed since the start. If ctx is done first, its error
+// is returned. On errors, the statistics only have packet counts, if
+// any. The socket determines the source, so only the destination
+// address is used from dst.
+func runPing(ctx context.Context, s *icmpSocket, dst *net.IPAddr, count int, interval, timeout time.Duration) (*ping.Statistics, error) {
+	// A random payload protects against stale replies to reused
+	// sequence numbers.
+	sess := &pingSession{
+		payload: make([]byte, pingPayloadSize),
+		replies: make(chan pingReply, count),
+	}
+	if _, err := rand.Read(sess.payload); err != nil {
+		return nil, err
+	}
+	defer s.end(sess)
+
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	st := &ping.Statistics{IPAddr: dst, Addr: dst.String()}
+	var rtts []time.Duration
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	if err := s.send(sess, dst); err != nil {
+		return st, err
+	}
+	st.PacketsSent++
+
+loop:
+	for len(rtts) < count {
+		tC := t.C
+		if st.PacketsSent == count {
+			tC = nil
+		}
+
+		select {
+		case <-tC:
+			if err := s.send(sess, dst); err != nil {
+				st.PacketsRecv = len(rtts)
+				return st, err
+			}
+			st.PacketsSent++
+
+		case r := <-sess.replies:
+			// Each request is only pending until its first
+			// reply, so there are no duplicates.
+			rtts = append(rtts, r.RTT)
+
+		case <-pctx.Done():
+			break loop
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// The caller gave up, so the statistics are incomplete,
+		// but the packet counts are still data used.
+		st.PacketsRecv = len(rtts)
+		return st, err
+	}
+
+	st.PacketsRecv = len(rtts)
+	fillPingStatistics(st, rtts)
+	return st, nil
+}
+
+// echoAddr returns the destination address in the form the socket
+// wants. Raw sockets use IP addresses, and datagram sockets use UDP
+// addresses.
+func echoAddr(conn net.PacketConn, dst *net.IPAddr) net.Addr {
+	if _, ok := conn.LocalAddr().(*net.IPAddr); ok {
+		return dst
+	}
+	return &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+}
+
+// fillPingStatistics computes the loss and RTT aggregates, as the
+// ping package does.
+func fillPingStatistics(st *ping.Statistics, rtts []time.Duration) {
+	if st.PacketsSent > 0 {
+		st.PacketLoss = float64(st.PacketsSent-st.PacketsRecv) / float64(st.PacketsSent) * 100
+	}
+	if len(rtts) == 0 {
+		return
+	}
+
+	st.MinRtt, st.MaxRtt = rtts[0], rtts[0]
+	var sum time.Duration
+	for _, rtt := range rtts {
+		if rtt < st.MinRtt {
+			st.MinRtt = rtt
+		}
+		if rtt > st.MaxRtt {
+			st.MaxRtt = rtt
+		}
+		sum += rtt
+	}
+	st.AvgRtt = sum / time.Duration(len(rtts))
+
+	var sumsq float64
+	for _, rtt := range rtts {
+		d := float64(rtt - st.AvgRtt)
+		sumsq += d * d
+	}
+	st.StdDevRtt = time.Duration(math.Sqrt(sumsq / float64(len(rtts))))
+}
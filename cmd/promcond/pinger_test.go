@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestICMPEngineClose(t *testing.T) {
+	if os.Getenv("CI") == "true" {
+		t.Skip("Ping test requires privileges CircleCI/Docker doesn't provide")
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	e := newICMPEngine()
+	if _, err := e.socket("ip4", SocketOptions{}); err != nil {
+		t.Fatalf("socket failed: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("Close logged %q, want nothing", buf.String())
+	}
+	if _, err := e.socket("ip4", SocketOptions{}); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("socket after Close: got %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestICMPEngine(t *testing.T) {
+	if os.Getenv("CI") == "true" {
+		t.Skip("Ping test requires privileges CircleCI/Docker doesn't provide")
+	}
+
+	tsts := []struct {
+		Name       string
+		Privileged bool
+	}{
+		{"unprivileged", false},
+		{"privileged", true},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if tst.Privileged {
+				if os.Geteuid() != 0 {
+					t.Skip("Raw sockets require root")
+				}
+				icmpPrivileged = true
+				defer func() {
+					icmpPrivileged = false
+				}()
+			}
+
+			e := newICMPEngine()
+			defer e.Close()
+
+			ctx := context.Background()
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				for _, dst := range []*net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}, {IP: net.IPv6loopback}} {
+					network := "ip4"
+					if dst.IP.To4() == nil {
+						network = "ip6"
+					}
+
+					wg.Add(1)
+					go func(network string, dst *net.IPAddr) {
+						defer wg.Done()
+
+						s, err := e.socket(network, SocketOptions{})
+						if err != nil {
+							t.Errorf("socket failed: %v", err)
+							return
+						}
+						st, err := runPing(ctx, s, dst, 5, time.Millisecond, time.Second)
+						if err != nil {
+							t.Errorf("runPing failed: %v", err)
+							return
+						}
+						if st.PacketsRecv != 5 {
+							t.Errorf("runPing PacketsRecv: got %v, want 5", st.PacketsRecv)
+						}
+					}(network, dst)
+				}
+			}
+			wg.Wait()
+
+			if got, want := len(e.socks), 2; got != want {
+				t.Errorf("sockets: got %d, want %d", got, want)
+			}
+			for _, s := range e.socks {
+				if len(s.pending) != 0 {
+					t.Errorf("pending: got %v, want none", s.pending)
+				}
+			}
+		})
+	}
+}
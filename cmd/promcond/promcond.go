@@ -8,15 +8,72 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	httpAddr      = flag.String("http-addr", "localhost:0", "TCP-address to listen for HTTP connections on.")
-	standaloneLog = flag.Bool("standalone-log", true, "Log to stderr, with time prefix.")
-	checks        = checkSliceFlag("check", "Add a check to perform, in the format 'kind=X,af=Y,host=Z,service=W,interval=T'.")
+	httpAddr         = flag.String("http-addr", "localhost:0", "TCP-address to listen for HTTP connections on.")
+	standaloneLog    = flag.Bool("standalone-log", true, "Log to stderr, with time prefix.")
+	linkStats        = flag.Bool("link-stats", true, "Export statistics of the interfaces that checks use.")
+	routePoll        = flag.Duration("route-poll-interval", 30*time.Second, "How often to read the routing tables, if route change notifications are unavailable.")
+	outageFailures   = flag.Int("outage-failures", outageFailureThreshold, "Consecutive failed runs of a check that start an outage.")
+	outageRecoveries = flag.Int("outage-recoveries", outageRecoveryThreshold, "Consecutive successful runs of a check that end an outage.")
+	staleRuns        = flag.Int("stale-after-runs", staleAfterRuns, "Consecutive runs of a check without a new measurement after which its series are stale. Zero disables it.")
+	staleAge         = flag.Duration("stale-max-age", staleMaxAge, "Age at which a measurement is stale. Zero disables it.")
+	staleActionFlag  = flag.String("stale-action", staleAction, "What to do with stale series: delete them, or set them to nan.")
+	historyFile      = flag.String("history-file", "", "Append the results of all check runs to this line-delimited JSON file. Empty disables history.")
+	historyMaxSize   = flag.Int64("history-max-size", 16<<20, "Size in bytes at which the history file is rotated.")
+	historyMaxAge    = flag.Duration("history-max-age", 90*24*time.Hour, "Age at which rotated history files are removed.")
+	maxConcurrent    = flag.Int("max-concurrent-checks", 4, "How many checks may run at the same time. Zero means no limit. Transfer and flood checks always run alone.")
+	startImmediately = flag.Bool("start-immediately", false, "Run all checks once at startup, instead of waiting for their phase.")
+	interfaceBudgets = interfaceBudgetFlag("interface-budget", "Limit the data all checks bound to an interface may use, like 'wwan0=1GB/month'. Can be repeated.")
+	privilegedICMP   = flag.Bool("icmp-privileged", false, "Use raw ICMP sockets for ping checks. Requires CAP_NET_RAW.")
+	checks           = checkSliceFlag("check", "Add a check to perform, in the format 'kind=X,af=Y,host=Z,service=W,interval=T'.")
+
+	// -dry-run is handled by main, before flag.Parse would stop at
+	// the first invalid -check.
+	_ = flag.Bool("dry-run", false, "Validate the configuration, report all problems and exit.")
 )
 
+// subcommands are run instead of the exporter, if named by the first
+// argument.
+var subcommands = map[string]func(args []string) error{
+	"check": func(args []string) error {
+		defer sharedICMP.Close()
+		return runCheckCommand(context.Background(), args, os.Stdout, checker{}, detectCapabilities)
+	},
+	"report": func(args []string) error {
+		return runReport(args, os.Stdout)
+	},
+	"validate": func(args []string) error {
+		return runValidate(context.Background(), args, os.Stdout, defaultResolver)
+	},
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		cmd, ok := subcommands[os.Args[1]]
+		args := os.Args[2:]
+		if !ok && hasDryRunFlag(os.Args[1:]) {
+			cmd, ok, args = subcommands["validate"], true, os.Args[1:]
+		}
+		if ok {
+			switch err := cmd(args); {
+			case err == flag.ErrHelp:
+				os.Exit(2)
+			case err == errChecksFailed, err == errInvalidConfig:
+				os.Exit(1)
+			case err != nil:
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if err := run(context.Background()); err != nil {
@@ -24,6 +81,29 @@ func main() {
 	}
 }
 
+// hasDryRunFlag returns whether the arguments enable -dry-run.
+func hasDryRunFlag(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		value := "true"
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if name != "dry-run" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		return err == nil && b
+	}
+	return false
+}
+
 // run starts everything and waits for a signal to terminate.
 func run(ctx context.Context) error {
 	if !*standaloneLog {
@@ -34,7 +114,72 @@ func run(ctx context.Context) error {
 	if len(*checks) == 0 {
 		return fmt.Errorf("no -check flags provided")
 	}
-	startChecks(ctx, *checks, checker{})
+	expanded, err := expandAllGateways(ctx, *checks, defaultResolver)
+	if err != nil {
+		return err
+	}
+	*checks = expanded
+	if err := validateDependencies(*checks); err != nil {
+		return err
+	}
+	if errs := validateLabelSets(*checks); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		return fmt.Errorf("checks must export distinct series: give them different names or labels")
+	}
+	if *outageFailures < 1 || *outageRecoveries < 1 {
+		return fmt.Errorf("-outage-failures and -outage-recoveries must be at least one")
+	}
+	outageFailureThreshold, outageRecoveryThreshold = *outageFailures, *outageRecoveries
+
+	if *staleRuns < 0 || *staleAge < 0 {
+		return fmt.Errorf("-stale-after-runs and -stale-max-age must not be negative")
+	}
+	action, err := parseStaleAction(*staleActionFlag)
+	if err != nil {
+		return err
+	}
+	staleAfterRuns, staleMaxAge, staleAction = *staleRuns, *staleAge, action
+
+	if *maxConcurrent < 0 {
+		return fmt.Errorf("-max-concurrent-checks must not be negative")
+	}
+	checkScheduler = newScheduler(*maxConcurrent)
+	setCheckLabelKeys(checkLabelKeysOf(*checks))
+	budgets = newBudgetMeter(interfaceBudgets)
+
+	if *historyFile != "" {
+		h, err := openHistory(*historyFile, *historyMaxSize, *historyMaxAge)
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+		history = h
+
+		if err := budgets.restore(*historyFile, *checks, time.Now()); err != nil {
+			log.Printf("Failed to restore data usage from history: %v", err)
+		}
+	}
+
+	icmpPrivileged = *privilegedICMP
+	caps := detectCapabilities()
+	caps.export()
+	*checks = applyCapabilities(caps, *checks)
+	if len(*checks) == 0 {
+		return fmt.Errorf("all checks are disabled for lack of capabilities")
+	}
+	if err := validateDependencies(*checks); err != nil {
+		return err
+	}
+
+	defer sharedICMP.Close()
+	startChecks(ctx, *checks, checker{}, *startImmediately)
+	go watchRoutes(ctx, *routePoll)
+
+	if *linkStats {
+		prometheus.MustRegister(newLinkCollector(*checks))
+	}
 
 	l, s, cleanup, err := startMetricsServer(ctx, *httpAddr)
 	if err != nil {
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestHasDryRunFlag(t *testing.T) {
+	tsts := []struct {
+		Args []string
+		Want bool
+	}{
+		{[]string{"-check", "kind=ping"}, false},
+		{[]string{"-check", "kind=ping", "-dry-run"}, true},
+		{[]string{"--dry-run", "-check", "kind=ping"}, true},
+		{[]string{"-dry-run=true"}, true},
+		{[]string{"-dry-run=false"}, false},
+		{[]string{"--", "-dry-run"}, false},
+		{[]string{"dry-run"}, false},
+	}
+	for _, tst := range tsts {
+		if got := hasDryRunFlag(tst.Args); got != tst.Want {
+			t.Errorf("hasDryRunFlag(%q): got %v, want %v", tst.Args, got, tst.Want)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// reportPercentiles are the percentiles of latency and throughput in
+// reports.
+var reportPercentiles = []float64{50, 90, 99}
+
+// runReport implements the report subcommand. It summarizes the
+// history file for a time range.
+func runReport(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	path := fs.String("history-file", "", "The history file written by -history-file.")
+	fromStr := fs.String("from", "", "Start of the report, as RFC 3339 or YYYY-MM-DD. The default is 30 days before -to.")
+	toStr := fs.String("to", "", "End of the report (exclusive), as RFC 3339 or YYYY-MM-DD. The default is now.")
+	format := fs.String("format", "text", "Output format. One of text, csv and html.")
+	failures := fs.Int("outage-failures", outageFailureThreshold, "Consecutive failed runs of a check that start an outage.")
+	recoveries := fs.Int("outage-recoveries", outageRecoveryThreshold, "Consecutive successful runs of a check that end an outage.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		return fmt.Errorf("no -history-file provided")
+	}
+	if *failures < 1 || *recoveries < 1 {
+		return fmt.Errorf("-outage-failures and -outage-recoveries must be at least one")
+	}
+	to := time.Now()
+	if *toStr != "" {
+		var err error
+		to, err = parseReportTime(*toStr)
+		if err != nil {
+			return err
+		}
+	}
+	from := to.AddDate(0, 0, -30)
+	if *fromStr != "" {
+		var err error
+		from, err = parseReportTime(*fromStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	var write func(io.Writer, *report) error
+	switch *format {
+	case "text":
+		write = writeReportText
+	case "csv":
+		write = writeReportCSV
+	case "html":
+		write = writeReportHTML
+	default:
+		return fmt.Errorf("unknown report format: %s", *format)
+	}
+
+	r := newReport(from, to, *failures, *recoveries)
+	if err := readHistory(*path, from, to, func(rec *historyRecord) error {
+		r.add(rec)
+		return nil
+	}); err != nil {
+		return err
+	}
+	r.finish()
+
+	return write(w, r)
+}
+
+// parseReportTime parses an RFC 3339 time, or a local date.
+func parseReportTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// A report summarizes the history of checks.
+type report struct {
+	From, To time.Time
+	Checks   []*checkReport
+
+	failures, recoveries int
+	byKey                map[string]*checkReport
+}
+
+// A checkReport summarizes the history of one check.
+type checkReport struct {
+	// Check is a human readable identification of the check.
+	Check string
+
+	Runs     int
+	Failures int
+	Skipped  int
+
+	Outages []*outage
+
+	// Latencies are RTTs and connection latencies, in seconds.
+	Latencies []float64
+
+	// Throughputs are in bytes per second.
+	Throughputs []float64
+
+	// UpTime and DownTime are the times covered by successful and
+	// failed runs. Each run covers the time until the next run.
+	UpTime, DownTime time.Duration
+
+	detector outageDetector
+	last     time.Time
+	// lastOK is whether the run at last succeeded. lastGap is the
+	// time between the previous run and it.
+	lastOK  bool
+	lastGap time.Duration
+	// covering is whether the time since last is covered by it. It's
+	// false before the first run, and after a skipped run.
+	covering bool
+}
+
+func newReport(from, to time.Time, failures, recoveries int) *report {
+	return &report{
+		From:       from,
+		To:         to,
+		failures:   failures,
+		recoveries: recoveries,
+		byKey:      map[string]*checkReport{},
+	}
+}
+
+// add adds a record. Records of each check must be added in time
+// order.
+func (r *report) add(rec *historyRecord) {
+	key := rec.key()
+	cr := r.byKey[key]
+	if cr == nil {
+		cr = &checkReport{
+			Check:    reportCheckName(rec),
+			detector: outageDetector{Failures: r.failures, Recoveries: r.recoveries},
+		}
+		r.byKey[key] = cr
+		r.Checks = append(r.Checks, cr)
+	}
+
+	if rec.State == stateSkipped || rec.State == statePaused {
+		cr.Skipped++
+		cr.cover(rec.Time)
+		cr.covering = false
+		return
+	}
+	cr.Runs++
+	if rec.State != stateOK {
+		cr.Failures++
+	}
+	if o := cr.detector.observe(rec.Time, rec.State == stateOK); o != nil && o.End.IsZero() {
+		cr.Outages = append(cr.Outages, o)
+	}
+	cr.cover(rec.Time)
+	cr.last = rec.Time
+	cr.lastOK = rec.State == stateOK
+	cr.covering = true
+
+	if rec.State == stateOK {
+		for _, k := range []string{"rtt", "latency"} {
+			if v, ok := rec.Values[k]; ok {
+				cr.Latencies = append(cr.Latencies, v)
+				break
+			}
+		}
+		if v, ok := rec.Values["throughput"]; ok {
+			cr.Throughputs = append(cr.Throughputs, v)
+		}
+	}
+}
+
+// cover adds the time from the last run until t to UpTime or
+// DownTime.
+func (cr *checkReport) cover(t time.Time) {
+	if !cr.covering {
+		cr.lastGap = 0
+		return
+	}
+	cr.lastGap = t.Sub(cr.last)
+	if cr.lastOK {
+		cr.UpTime += cr.lastGap
+	} else {
+		cr.DownTime += cr.lastGap
+	}
+}
+
+// finish sorts checks and values. Ongoing outages are reported as
+// ending at the last run of the check. The last run covers as much
+// time as the run before it did.
+func (r *report) finish() {
+	sort.Slice(r.Checks, func(i, j int) bool { return r.Checks[i].Check < r.Checks[j].Check })
+	for _, cr := range r.Checks {
+		if cr.covering {
+			cr.cover(cr.last.Add(cr.lastGap))
+		}
+		sort.Float64s(cr.Latencies)
+		sort.Float64s(cr.Throughputs)
+		for _, o := range cr.Outages {
+			if o.End.IsZero() {
+				o.End = cr.last
+			}
+		}
+	}
+}
+
+// reportCheckName returns the name of the check, or a description if
+// it has no name, followed by its labels, if any.
+func reportCheckName(rec *historyRecord) string {
+	s := rec.Name
+	if s == "" {
+		s = rec.Kind + " " + rec.Network + "/" + rec.Host
+		if rec.Service != "" {
+			s += ":" + rec.Service
+		}
+		if rec.Interface != "" {
+			s += " via " + rec.Interface
+		}
+	}
+	if len(rec.Labels) > 0 {
+		s += " {" + strings.Join(labelPairs(rec.Labels), ",") + "}"
+	}
+	return s
+}
+
+// Availability is the percentage of the covered time that the check
+// was succeeding, or NaN if there were no runs. Weighting by time
+// keeps retries at fail_interval from skewing it. If no run covers
+// any time, it's the percentage of runs that succeeded.
+func (cr *checkReport) Availability() float64 {
+	if cr.Runs == 0 {
+		return math.NaN()
+	}
+	if total := cr.UpTime + cr.DownTime; total > 0 {
+		return 100 * float64(cr.UpTime) / float64(total)
+	}
+	return 100 * float64(cr.Runs-cr.Failures) / float64(cr.Runs)
+}
+
+// OutageTime is the total duration of outages.
+func (cr *checkReport) OutageTime() time.Duration {
+	var d time.Duration
+	for _, o := range cr.Outages {
+		d += o.End.Sub(o.Start)
+	}
+	return d
+}
+
+// LatencyPercentiles returns the reportPercentiles of Latencies.
+func (cr *checkReport) LatencyPercentiles() []float64 {
+	return percentiles(cr.Latencies, reportPercentiles)
+}
+
+// ThroughputPercentiles returns the reportPercentiles of
+// Throughputs.
+func (cr *checkReport) ThroughputPercentiles() []float64 {
+	return percentiles(cr.Throughputs, reportPercentiles)
+}
+
+// percentiles returns the nearest-rank percentiles of sorted
+// values. They are NaN if there are no values.
+func percentiles(sorted []float64, ps []float64) []float64 {
+	ret := make([]float64, len(ps))
+	for i, p := range ps {
+		if len(sorted) == 0 {
+			ret[i] = math.NaN()
+			continue
+		}
+		n := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if n < 1 {
+			n = 1
+		}
+		ret[i] = sorted[n-1]
+	}
+	return ret
+}
+
+// formatLatency formats seconds as milliseconds, or "-" for NaN.
+func formatLatency(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v*1000, 'f', 1, 64) + " ms"
+}
+
+// formatThroughput formats bytes per second as Mbit/s, or "-" for
+// NaN.
+func formatThroughput(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v*8/1000/1000, 'f', 2, 64) + " Mbit/s"
+}
+
+// formatPercent formats a percentage, or "-" for NaN.
+func formatPercent(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64) + " %"
+}
+
+func writeReportText(w io.Writer, r *report) error {
+	fmt.Fprintf(w, "Report from %s to %s\n\n", r.From.Format(time.RFC3339), r.To.Format(time.RFC3339))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRUNS\tFAILURES\tAVAILABILITY\tOUTAGES\tOUTAGE TIME\tLATENCY P50/P90/P99\tTHROUGHPUT P50/P90/P99")
+	for _, cr := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\t%v\t%s\t%s\n",
+			cr.Check, cr.Runs, cr.Failures, formatPercent(cr.Availability()), len(cr.Outages), cr.OutageTime(),
+			joinFormatted(cr.LatencyPercentiles(), formatLatency), joinFormatted(cr.ThroughputPercentiles(), formatThroughput))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, cr := range r.Checks {
+		if len(cr.Outages) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nOutages of %s:\n", cr.Check)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "  START\tEND\tDURATION")
+		for _, o := range cr.Outages {
+			fmt.Fprintf(tw, "  %s\t%s\t%v\n", o.Start.Format(time.RFC3339), o.End.Format(time.RFC3339), o.End.Sub(o.Start))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinFormatted(vs []float64, format func(float64) string) string {
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = format(v)
+	}
+	return strings.Join(ss, " / ")
+}
+
+// writeReportCSV writes one "summary" row per check, and one "outage"
+// row per outage. Values are in seconds and bytes per second.
+func writeReportCSV(w io.Writer, r *report) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"type", "check", "runs", "failures", "availability", "outages", "outage_seconds"}
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("latency_p%g", p))
+	}
+	for _, p := range reportPercentiles {
+		header = append(header, fmt.Sprintf("throughput_p%g", p))
+	}
+	header = append(header, "outage_start", "outage_end")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	formatFloat := func(v float64) string {
+		if math.IsNaN(v) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	for _, cr := range r.Checks {
+		row := []string{
+			"summary",
+			cr.Check,
+			strconv.Itoa(cr.Runs),
+			strconv.Itoa(cr.Failures),
+			formatFloat(cr.Availability()),
+			strconv.Itoa(len(cr.Outages)),
+			formatFloat(cr.OutageTime().Seconds()),
+		}
+		for _, v := range append(cr.LatencyPercentiles(), cr.ThroughputPercentiles()...) {
+			row = append(row, formatFloat(v))
+		}
+		row = append(row, "", "")
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	for _, cr := range r.Checks {
+		for _, o := range cr.Outages {
+			row := make([]string, len(header))
+			row[0] = "outage"
+			row[1] = cr.Check
+			row[6] = formatFloat(o.End.Sub(o.Start).Seconds())
+			row[len(row)-2] = o.Start.Format(time.RFC3339)
+			row[len(row)-1] = o.End.Format(time.RFC3339)
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":       func(t time.Time) string { return t.Format(time.RFC3339) },
+	"percent":    formatPercent,
+	"latency":    formatLatency,
+	"throughput": formatThroughput,
+	"duration":   func(o *outage) time.Duration { return o.End.Sub(o.Start) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Connectivity report {{time .From}} to {{time .To}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Connectivity report</h1>
+<p>From {{time .From}} to {{time .To}}.</p>
+<table>
+<tr><th>Check</th><th>Runs</th><th>Failures</th><th>Availability</th><th>Outages</th><th>Outage time</th><th>Latency P50</th><th>P90</th><th>P99</th><th>Throughput P50</th><th>P90</th><th>P99</th></tr>
+{{range .Checks}}<tr><td>{{.Check}}</td><td>{{.Runs}}</td><td>{{.Failures}}</td><td>{{percent .Availability}}</td><td>{{len .Outages}}</td><td>{{.OutageTime}}</td>{{range .LatencyPercentiles}}<td>{{latency .}}</td>{{end}}{{range .ThroughputPercentiles}}<td>{{throughput .}}</td>{{end}}</tr>
+{{end}}</table>
+{{range .Checks}}{{if .Outages}}<h2>Outages of {{.Check}}</h2>
+<table>
+<tr><th>Start</th><th>End</th><th>Duration</th></tr>
+{{range .Outages}}<tr><td>{{time .Start}}</td><td>{{time .End}}</td><td>{{duration .}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+func writeReportHTML(w io.Writer, r *report) error {
+	return reportHTMLTemplate.Execute(w, r)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	w, err := openHistory(path, 0, 0)
+	if err != nil {
+		t.Fatalf("openHistory failed: %v", err)
+	}
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	ping := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "example.com"}
+	for i, ok := range []bool{true, true, false, false, false, true, true, true, true, true} {
+		rec := newHistoryRecord(ping, start.Add(time.Duration(i)*time.Minute), time.Second, stateOK, map[string]float64{"rtt": float64(i+1) / 1000}, nil)
+		if !ok {
+			rec = newHistoryRecord(ping, start.Add(time.Duration(i)*time.Minute), time.Second, stateFailed, nil, errors.New("mocked error"))
+		}
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	transfer := &ConnectivityCheck{Name: "echo", Kind: KindTransfer, Network: "ip", Host: "example.com", Service: "echo"}
+	if err := w.Write(newHistoryRecord(transfer, start, time.Second, stateOK, map[string]float64{"latency": 0.02, "throughput": 1e6}, nil)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	tsts := []struct {
+		Format string
+		Want   []string
+	}{
+		{"text", []string{
+			"ping ip/example.com  10    3         70.000 %      1        3m0s",
+			"7.0 ms / 10.0 ms / 10.0 ms",
+			"echo                 1     0         100.000 %",
+			"8.00 Mbit/s",
+			"2021-06-01T12:02:00Z  2021-06-01T12:05:00Z  3m0s",
+		}},
+		{"csv", []string{
+			"type,check,runs,failures,availability,outages,outage_seconds,latency_p50,latency_p90,latency_p99,throughput_p50,throughput_p90,throughput_p99,outage_start,outage_end\n",
+			"summary,ping ip/example.com,10,3,70,1,180,0.007,0.01,0.01,,,,,\n",
+			"summary,echo,1,0,100,0,0,0.02,0.02,0.02,1e+06,1e+06,1e+06,,\n",
+			"outage,ping ip/example.com,,,,,180,,,,,,,2021-06-01T12:02:00Z,2021-06-01T12:05:00Z\n",
+		}},
+		{"html", []string{
+			"<td>ping ip/example.com</td><td>10</td><td>3</td><td>70.000 %</td>",
+			"<h2>Outages of ping ip/example.com</h2>",
+		}},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := runReport([]string{"-history-file", path, "-from", "2021-06-01T00:00:00Z", "-to", "2021-06-02T00:00:00Z", "-format", tst.Format}, &buf); err != nil {
+				t.Fatalf("runReport failed: %v", err)
+			}
+			for _, want := range tst.Want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("runReport: got %q, want containing %q", buf.String(), want)
+				}
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runReport([]string{"-history-file", path, "-from", "2021-06-02", "-to", "2021-06-03"}, &buf); err != nil {
+			t.Fatalf("runReport failed: %v", err)
+		}
+		if strings.Contains(buf.String(), "example.com") {
+			t.Errorf("runReport: got %q, want no checks", buf.String())
+		}
+	})
+}
+
+func TestReportLabels(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	lte := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "example.com", Labels: map[string]string{"uplink": "lte"}}
+	dsl := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "example.com", Labels: map[string]string{"uplink": "dsl"}}
+	r := newReport(start, start.Add(time.Hour), 3, 2)
+	r.add(newHistoryRecord(lte, start, time.Second, stateOK, nil, nil))
+	r.add(newHistoryRecord(dsl, start, time.Second, stateFailed, nil, nil))
+	r.finish()
+
+	if len(r.Checks) != 2 {
+		t.Fatalf("Checks: got %d, want 2", len(r.Checks))
+	}
+	for i, want := range []string{"ping ip/example.com {uplink=dsl}", "ping ip/example.com {uplink=lte}"} {
+		if got := r.Checks[i].Check; got != want {
+			t.Errorf("Check: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReportAvailability(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	chk := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "example.com", Interval: 10 * time.Minute, FailInterval: time.Minute}
+	r := newReport(start, start.Add(time.Hour), 3, 2)
+	for _, run := range []struct {
+		At    time.Duration
+		State string
+	}{
+		{0, stateOK},
+		{10 * time.Minute, stateFailed},
+		{11 * time.Minute, stateFailed},
+		{12 * time.Minute, stateOK},
+		{22 * time.Minute, stateOK},
+		{32 * time.Minute, stateSkipped},
+		{52 * time.Minute, stateOK},
+	} {
+		r.add(newHistoryRecord(chk, start.Add(run.At), time.Second, run.State, nil, nil))
+	}
+	r.finish()
+
+	cr := r.Checks[0]
+	if want := 30 * time.Minute; cr.UpTime != want {
+		t.Errorf("UpTime: got %v, want %v", cr.UpTime, want)
+	}
+	if want := 2 * time.Minute; cr.DownTime != want {
+		t.Errorf("DownTime: got %v, want %v", cr.DownTime, want)
+	}
+	if got, want := cr.Availability(), 100*30.0/32; got != want {
+		t.Errorf("Availability: got %v, want %v", got, want)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	got := percentiles([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []float64{0, 50, 90, 100})
+	want := []float64{1, 5, 9, 10}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("percentiles: got %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -2,24 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
-
-	"github.com/jackpal/gateway"
+	"strings"
 )
 
 // defautResolver is the default resolver for the collector.
 var defaultResolver = &keywordResolver{
-	netResolver:     net.DefaultResolver,
-	discoverGateway: gateway.DiscoverGateway,
+	netResolver:      net.DefaultResolver,
+	discoverGateway:  discoverDefaultGateway,
+	discoverGateways: discoverAllGateways,
+	discoverNS:       discoverNameserver,
+	discoverFirstHop: discoverFirstHop,
+	discoverPublicIP: discoverPublicIP,
 }
 
+// errNameResolution is wrapped by errors of looking up names in DNS,
+// so the diagnosis can blame DNS rather than the layer of the check.
+var errNameResolution = errors.New("name resolution failed")
+
 // A keywordResolver intercepts some lookups to resolve magic
-// keywords.
+// keywords. IPv6 zones are kept, so link-local addresses can be used.
 //
-//  default-gateway.internal - Resolves to one of the default gateways of the host.
+//  default-gateway.internal  - Resolves to the default gateway for the address family, preferring IPv4.
+//  default-gateway6.internal - Resolves to the IPv6 default gateway of the host.
+//  all-gateways.internal     - Resolves to all default gateways of the host. See expandAllGateways.
+//  nameserver.internal       - Resolves to the first nameserver in resolv.conf.
+//  first-hop.internal        - Resolves to the first router beyond the default gateway.
+//  public-ip.internal        - Resolves to the address the Internet sees the host as.
 type keywordResolver struct {
 	netResolver
-	discoverGateway func() (net.IP, error)
+	discoverGateway  func(network string) (*net.IPAddr, error)
+	discoverGateways func() ([]net.IPAddr, error)
+	discoverNS       func() (string, error)
+	discoverFirstHop func(ctx context.Context, network string) (net.IP, error)
+	discoverPublicIP func(ctx context.Context, network string) (net.IP, error)
 }
 
 // A netResolver is our interest in a net.Resolver.
@@ -28,15 +46,127 @@ type netResolver interface {
 	LookupPort(context.Context, string, string) (int, error)
 }
 
-func (r *keywordResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+// A targetResolver is what checks use to resolve their targets.
+type targetResolver interface {
+	LookupIPAddr(ctx context.Context, network, host string) ([]net.IPAddr, error)
+	LookupPort(ctx context.Context, network, service string) (int, error)
+}
+
+// LookupIPAddr is like net.Resolver.LookupIP, but keeps IPv6 zones.
+func (r *keywordResolver) LookupIPAddr(ctx context.Context, network, host string) ([]net.IPAddr, error) {
 	switch host {
 	case "default-gateway.internal":
-		ip, err := r.discoverGateway()
+		addr, err := r.discoverGateway(network)
+		if err != nil {
+			return nil, err
+		}
+		host = addr.String()
+
+	case "default-gateway6.internal":
+		if network == "ip4" {
+			return nil, fmt.Errorf("%s is not usable with network %s", host, network)
+		}
+		addr, err := r.discoverGateway("ip6")
+		if err != nil {
+			return nil, err
+		}
+		host = addr.String()
+
+	case allGatewaysHost:
+		return r.lookupAllGateways(ctx, network)
+
+	case "nameserver.internal":
+		var err error
+		host, err = r.discoverNS()
+		if err != nil {
+			return nil, err
+		}
+
+	case "first-hop.internal":
+		ip, err := r.discoverFirstHop(ctx, network)
 		if err != nil {
 			return nil, err
 		}
 		host = ip.String()
+
+	case "public-ip.internal":
+		ip, err := r.discoverPublicIP(ctx, network)
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+
+	return r.lookupIPAddr(ctx, network, host)
+}
+
+// allGatewaysHost is the keyword of all default gateways.
+const allGatewaysHost = "all-gateways.internal"
+
+// expandAllGateways replaces each check of allGatewaysHost with one
+// check per gateway, since a check only probes one address. The
+// gateways are looked up once, so the checks are fixed at startup.
+func expandAllGateways(ctx context.Context, checks []ConnectivityCheck, res targetResolver) ([]ConnectivityCheck, error) {
+	var ret []ConnectivityCheck
+	for _, chk := range checks {
+		if chk.Host != allGatewaysHost {
+			ret = append(ret, chk)
+			continue
+		}
+		addrs, err := res.LookupIPAddr(ctx, chk.Network, chk.Host)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %v", chk.describe(), err)
+		}
+		for _, addr := range addrs {
+			gw := chk
+			gw.Host = addr.String()
+			ret = append(ret, gw)
+		}
+	}
+	return ret, nil
+}
+
+// lookupAllGateways resolves all gateways usable with the network.
+func (r *keywordResolver) lookupAllGateways(ctx context.Context, network string) ([]net.IPAddr, error) {
+	gws, err := r.discoverGateways()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []net.IPAddr
+	for _, gw := range gws {
+		if !networkMatchesIP(network, gw.IP) {
+			continue
+		}
+		gwaddrs, err := r.lookupIPAddr(ctx, network, gw.String())
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, gwaddrs...)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no %s gateways found", network)
+	}
+	return addrs, nil
+}
+
+// lookupIPAddr resolves the host using the netResolver. The
+// net.Resolver drops the zone of an address literal, so it's split
+// off before, and added back after.
+func (r *keywordResolver) lookupIPAddr(ctx context.Context, network, host string) ([]net.IPAddr, error) {
+	var zone string
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host, zone = host[:i], host[i+1:]
 	}
 
-	return r.netResolver.LookupIP(ctx, network, host)
+	ips, err := r.netResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNameResolution, err)
+	}
+
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: ip, Zone: zone})
+	}
+	return addrs, nil
 }
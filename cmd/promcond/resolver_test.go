@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
 	"reflect"
 	"testing"
@@ -16,14 +17,14 @@ func TestKeywordResolver(t *testing.T) {
 			netResolver: &fnr,
 		}
 
-		got, err := res.LookupIP(ctx, "anetwork", "ahost")
+		got, err := res.LookupIPAddr(ctx, "anetwork", "ahost")
 		if err != nil {
 			t.Fatalf("failed: %v", err)
 		}
 		if want := []lookupIPCall{{"anetwork", "ahost"}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
 			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
 		}
-		if want := []net.IP{net.IPv4bcast}; !reflect.DeepEqual(got, want) {
+		if want := []net.IPAddr{{IP: net.IPv4bcast}}; !reflect.DeepEqual(got, want) {
 			t.Errorf("got %+v, want %+v", got, want)
 		}
 	})
@@ -32,26 +33,159 @@ func TestKeywordResolver(t *testing.T) {
 		var fnr fakeNetResolver
 		res := &keywordResolver{
 			netResolver: &fnr,
-			discoverGateway: func() (net.IP, error) {
-				return net.IPv4allsys, nil
+			discoverGateway: func(network string) (*net.IPAddr, error) {
+				return &net.IPAddr{IP: net.IPv4allsys}, nil
 			},
 		}
 
-		got, err := res.LookupIP(ctx, "anetwork", "default-gateway.internal")
+		got, err := res.LookupIPAddr(ctx, "anetwork", "default-gateway.internal")
 		if err != nil {
 			t.Fatalf("failed: %v", err)
 		}
 		if want := []lookupIPCall{{"anetwork", net.IPv4allsys.String()}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
 			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
 		}
-		if want := []net.IP{net.IPv4bcast}; !reflect.DeepEqual(got, want) {
+		if want := []net.IPAddr{{IP: net.IPv4bcast}}; !reflect.DeepEqual(got, want) {
 			t.Errorf("got %+v, don't want %+v", got, want)
 		}
 	})
+	t.Run("LookupIP_gw6", func(t *testing.T) {
+		var fnr fakeNetResolver
+		res := &keywordResolver{
+			netResolver: &fnr,
+			discoverGateway: func(network string) (*net.IPAddr, error) {
+				if network != "ip6" {
+					t.Errorf("discoverGateway network: got %q, want %q", network, "ip6")
+				}
+				return &net.IPAddr{IP: net.IPv6linklocalallrouters, Zone: "eth0"}, nil
+			},
+		}
+
+		got, err := res.LookupIPAddr(ctx, "anetwork", "default-gateway6.internal")
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if want := []lookupIPCall{{"anetwork", net.IPv6linklocalallrouters.String()}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
+			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
+		}
+		if want := []net.IPAddr{{IP: net.IPv4bcast, Zone: "eth0"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("LookupIP_allgws", func(t *testing.T) {
+		tsts := []struct {
+			Network string
+			Want    []lookupIPCall
+		}{
+			{"ip", []lookupIPCall{{"ip", net.IPv4allsys.String()}, {"ip", net.IPv6linklocalallrouters.String()}}},
+			{"ip4", []lookupIPCall{{"ip4", net.IPv4allsys.String()}}},
+			{"ip6", []lookupIPCall{{"ip6", net.IPv6linklocalallrouters.String()}}},
+		}
+		for _, tst := range tsts {
+			t.Run(tst.Network, func(t *testing.T) {
+				var fnr fakeNetResolver
+				res := &keywordResolver{
+					netResolver: &fnr,
+					discoverGateways: func() ([]net.IPAddr, error) {
+						return []net.IPAddr{{IP: net.IPv4allsys}, {IP: net.IPv6linklocalallrouters}}, nil
+					},
+				}
+
+				got, err := res.LookupIPAddr(ctx, tst.Network, "all-gateways.internal")
+				if err != nil {
+					t.Fatalf("failed: %v", err)
+				}
+				if !reflect.DeepEqual(fnr.LookupIPCalls, tst.Want) {
+					t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, tst.Want)
+				}
+				if len(got) != len(tst.Want) {
+					t.Errorf("got %+v, want %d addresses", got, len(tst.Want))
+				}
+			})
+		}
+	})
+
+	t.Run("LookupIP_ns", func(t *testing.T) {
+		var fnr fakeNetResolver
+		res := &keywordResolver{
+			netResolver: &fnr,
+			discoverNS: func() (string, error) {
+				return "fe80::1%eth0", nil
+			},
+		}
+
+		got, err := res.LookupIPAddr(ctx, "anetwork", "nameserver.internal")
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if want := []lookupIPCall{{"anetwork", "fe80::1"}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
+			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
+		}
+		if want := []net.IPAddr{{IP: net.IPv4bcast, Zone: "eth0"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("LookupIP_firsthop", func(t *testing.T) {
+		var fnr fakeNetResolver
+		var gotNetwork string
+		res := &keywordResolver{
+			netResolver: &fnr,
+			discoverFirstHop: func(_ context.Context, network string) (net.IP, error) {
+				gotNetwork = network
+				return net.IPv4allrouter, nil
+			},
+		}
+
+		if _, err := res.LookupIPAddr(ctx, "anetwork", "first-hop.internal"); err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if want := "anetwork"; gotNetwork != want {
+			t.Errorf("discoverFirstHop network: got %q, want %q", gotNetwork, want)
+		}
+		if want := []lookupIPCall{{"anetwork", net.IPv4allrouter.String()}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
+			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
+		}
+	})
+
+	t.Run("LookupIP_publicip", func(t *testing.T) {
+		var fnr fakeNetResolver
+		res := &keywordResolver{
+			netResolver: &fnr,
+			discoverPublicIP: func(context.Context, string) (net.IP, error) {
+				return net.IPv4zero, nil
+			},
+		}
+
+		if _, err := res.LookupIPAddr(ctx, "anetwork", "public-ip.internal"); err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if want := []lookupIPCall{{"anetwork", net.IPv4zero.String()}}; !reflect.DeepEqual(fnr.LookupIPCalls, want) {
+			t.Errorf("LookupIPCalls: got %+v, want %+v", fnr.LookupIPCalls, want)
+		}
+	})
+
+	t.Run("LookupIP_error", func(t *testing.T) {
+		res := &keywordResolver{
+			netResolver: &fakeNetResolver{Err: errors.New("mocked error")},
+			discoverGateway: func(string) (*net.IPAddr, error) {
+				return nil, errors.New("no gateway")
+			},
+		}
+
+		if _, err := res.LookupIPAddr(ctx, "ip", "example.com"); !errors.Is(err, errNameResolution) {
+			t.Errorf("LookupIPAddr err: got %v, want %v", err, errNameResolution)
+		}
+		if _, err := res.LookupIPAddr(ctx, "ip", "default-gateway.internal"); err == nil || errors.Is(err, errNameResolution) {
+			t.Errorf("LookupIPAddr err: got %v, want a non-DNS error", err)
+		}
+	})
 }
 
 type fakeNetResolver struct {
 	LookupIPCalls []lookupIPCall
+	Err           error
 }
 
 type lookupIPCall struct {
@@ -61,9 +195,41 @@ type lookupIPCall struct {
 
 func (r *fakeNetResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
 	r.LookupIPCalls = append(r.LookupIPCalls, lookupIPCall{network, host})
+	if r.Err != nil {
+		return nil, r.Err
+	}
 	return []net.IP{net.IPv4bcast}, nil
 }
 
 func (r *fakeNetResolver) LookupPort(_ context.Context, network, service string) (int, error) {
 	return 42, nil
 }
+
+func TestExpandAllGateways(t *testing.T) {
+	res := &keywordResolver{
+		// It resolves address literals without lookups.
+		netResolver: net.DefaultResolver,
+		discoverGateways: func() ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}, {IP: net.ParseIP("fe80::1"), Zone: "eth0"}}, nil
+		},
+	}
+	checks := []ConnectivityCheck{
+		{Kind: KindHostPing, Network: "ip", Host: "example.com"},
+		{Kind: KindHostPing, Network: "ip", Host: allGatewaysHost, Layer: LayerGateway},
+	}
+
+	got, err := expandAllGateways(context.Background(), checks, res)
+	if err != nil {
+		t.Fatalf("expandAllGateways failed: %v", err)
+	}
+	var hosts []string
+	for _, chk := range got {
+		hosts = append(hosts, chk.Host)
+		if chk.Host != "example.com" && chk.Layer != LayerGateway {
+			t.Errorf("Layer of %s: got %v, want %v", chk.Host, chk.Layer, LayerGateway)
+		}
+	}
+	if want := []string{"example.com", "192.0.2.1", "fe80::1%eth0"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("expandAllGateways hosts: got %v, want %v", hosts, want)
+	}
+}
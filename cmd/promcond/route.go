@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+var (
+	// procNetRoute is the Linux IPv4 routing table. It's a test
+	// injection point.
+	procNetRoute = "/proc/net/route"
+
+	// procNetIPv6Route is the Linux IPv6 routing table. It's a test
+	// injection point.
+	procNetIPv6Route = "/proc/net/ipv6_route"
+)
+
+// Flags from linux/route.h.
+const (
+	rtfUp      = 0x0001
+	rtfGateway = 0x0002
+)
+
+// A route is a default route of the host.
+type route struct {
+	Interface string
+	Gateway   net.IP
+	Metric    int
+}
+
+// IPAddr returns the gateway address. Link-local addresses are only
+// meaningful together with the interface, so they get a zone.
+func (r *route) IPAddr() *net.IPAddr {
+	addr := &net.IPAddr{IP: r.Gateway}
+	if r.Gateway.IsLinkLocalUnicast() && r.Gateway.To4() == nil {
+		addr.Zone = r.Interface
+	}
+	return addr
+}
+
+// readDefaultRoutes returns the IPv4 default routes, followed by the
+// IPv6 default routes. Within each address family, routes are sorted
+// by metric. A missing IPv6 routing table is not an error.
+func readDefaultRoutes() ([]route, error) {
+	rs, err := readRouteFile(procNetRoute, parseIPv4Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	rs6, err := readRouteFile(procNetIPv6Route, parseIPv6Routes)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return append(rs, rs6...), nil
+}
+
+func readRouteFile(path string, parse func(io.Reader) ([]route, error)) ([]route, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// parseIPv4Routes parses the default routes from the format of
+// /proc/net/route.
+//
+//  Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+//  eth0	00000000	010200C0	0003	0	0	0	00000000	0	0	0
+func parseIPv4Routes(r io.Reader) ([]route, error) {
+	var rs []route
+
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		// Missing header means an empty table.
+		return nil, s.Err()
+	}
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 8 {
+			return nil, fmt.Errorf("expected at least 8 fields in routing table, got %q", s.Text())
+		}
+		if fs[1] != "00000000" || fs[7] != "00000000" {
+			// Not a default route.
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fs[3], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		if flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+
+		if len(fs[2]) != 2*net.IPv4len {
+			return nil, fmt.Errorf("expected a %d byte gateway address, got %q", net.IPv4len, fs[2])
+		}
+		gw, err := strconv.ParseUint(fs[2], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		// The table prints the address as a number in host byte
+		// order.
+		ip := make(net.IP, net.IPv4len)
+		nativeEndian.PutUint32(ip, uint32(gw))
+
+		metric, err := strconv.Atoi(fs[6])
+		if err != nil {
+			return nil, err
+		}
+
+		rs = append(rs, route{Interface: fs[0], Gateway: ip, Metric: metric})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	sortRoutes(rs)
+	return rs, nil
+}
+
+// nativeEndian is the byte order of the host. It's a test injection
+// point.
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// parseIPv6Routes parses the default routes from the format of
+// /proc/net/ipv6_route. It has no header.
+//
+//  00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+func parseIPv6Routes(r io.Reader) ([]route, error) {
+	var rs []route
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 10 {
+			return nil, fmt.Errorf("expected at least 10 fields in routing table, got %q", s.Text())
+		}
+		if fs[1] != "00" {
+			// Not a default route.
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fs[8], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		if flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+
+		gw, err := hex.DecodeString(fs[4])
+		if err != nil {
+			return nil, err
+		}
+		if len(gw) != net.IPv6len {
+			return nil, fmt.Errorf("expected a %d byte gateway address, got %q", net.IPv6len, fs[4])
+		}
+
+		metric, err := strconv.ParseUint(fs[5], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		rs = append(rs, route{Interface: fs[9], Gateway: net.IP(gw), Metric: int(metric)})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	sortRoutes(rs)
+	return rs, nil
+}
+
+func sortRoutes(rs []route) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Metric < rs[j].Metric
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIPv4Routes(t *testing.T) {
+	defer func(bo binary.ByteOrder) {
+		nativeEndian = bo
+	}(nativeEndian)
+	nativeEndian = binary.LittleEndian
+
+	const s = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+		"eth0\t00000000\t010200C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+		"eth0\t000200C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"eth1\t00000000\t00000000\t0001\t0\t0\t0\t00000000\t0\t0\t0\n"
+
+	got, err := parseIPv4Routes(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("parseIPv4Routes failed: %v", err)
+	}
+
+	want := []route{
+		{Interface: "eth0", Gateway: net.IPv4(192, 0, 2, 1).To4(), Metric: 100},
+		{Interface: "wlan0", Gateway: net.IPv4(192, 168, 1, 1).To4(), Metric: 600},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIPv4Routes: got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseIPv4RoutesBigEndian(t *testing.T) {
+	defer func(bo binary.ByteOrder) {
+		nativeEndian = bo
+	}(nativeEndian)
+	nativeEndian = binary.BigEndian
+
+	const s = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\tC0A80101\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"
+
+	got, err := parseIPv4Routes(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("parseIPv4Routes failed: %v", err)
+	}
+
+	want := []route{{Interface: "eth0", Gateway: net.IPv4(192, 168, 1, 1).To4(), Metric: 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIPv4Routes: got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseIPv6Routes(t *testing.T) {
+	const s = "fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000200 00000001 00000000 00000003    wlan0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n"
+
+	got, err := parseIPv6Routes(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("parseIPv6Routes failed: %v", err)
+	}
+
+	want := []route{
+		{Interface: "wlan0", Gateway: net.ParseIP("fe80::1"), Metric: 0x200},
+		{Interface: "eth0", Gateway: net.ParseIP("fd00::1"), Metric: 0x400},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIPv6Routes: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultRouteInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "connectivity",
+		Name:      "default_route_info",
+		Help:      "A current default route of the instance. The primary route of each address family has primary=\"true\".",
+	}, []string{"af", "interface", "gateway", "metric", "primary"})
+	defaultRouteChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "connectivity",
+		Name:      "default_route_changes",
+		Help:      "How many times the set of default routes has changed.",
+	}, []string{"af"})
+	uplinkChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "connectivity",
+		Name:      "uplink_changes",
+		Help:      "How many times the primary default route has changed interface or gateway.",
+	}, []string{"af"})
+)
+
+func init() {
+	prometheus.MustRegister(defaultRouteInfo)
+	prometheus.MustRegister(defaultRouteChanges)
+	prometheus.MustRegister(uplinkChanges)
+}
+
+// watchRoutes keeps the default route metrics up to date until the
+// context is done. It reacts to route change notifications if the
+// platform supports them, and polls the routing tables otherwise.
+func watchRoutes(ctx context.Context, pollInterval time.Duration) {
+	var w routeWatcher
+	w.update()
+
+	var pollC <-chan time.Time
+	changes, err := subscribeRouteChanges(ctx)
+	if err != nil {
+		log.Printf("Failed to subscribe to route changes, polling every %v: %v", pollInterval, err)
+		t := time.NewTicker(pollInterval)
+		defer t.Stop()
+		pollC = t.C
+	}
+
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Route change subscription ended, polling every %v.", pollInterval)
+				changes = nil
+				t := time.NewTicker(pollInterval)
+				defer t.Stop()
+				pollC = t.C
+				continue
+			}
+			w.update()
+		case <-pollC:
+			w.update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// A routeWatcher remembers the last seen default routes, to detect
+// changes.
+type routeWatcher struct {
+	// routes are the default routes, by address family. Nil until
+	// the first successful update.
+	routes map[string][]route
+}
+
+// update reads the default routes and updates the metrics.
+func (w *routeWatcher) update() {
+	rs, err := readDefaultRoutes()
+	if err != nil {
+		log.Printf("Failed to read default routes: %v", err)
+		return
+	}
+
+	byAF := map[string][]route{}
+	for _, r := range rs {
+		af := "ip6"
+		if r.Gateway.To4() != nil {
+			af = "ip4"
+		}
+		byAF[af] = append(byAF[af], r)
+	}
+
+	if w.routes != nil {
+		for _, af := range []string{"ip4", "ip6"} {
+			prev, cur := w.routes[af], byAF[af]
+			if routesEqual(prev, cur) {
+				continue
+			}
+			defaultRouteChanges.WithLabelValues(af).Inc()
+
+			var prevUp, curUp *route
+			if len(prev) > 0 {
+				prevUp = &prev[0]
+			}
+			if len(cur) > 0 {
+				curUp = &cur[0]
+			}
+			if !uplinkEqual(prevUp, curUp) {
+				uplinkChanges.WithLabelValues(af).Inc()
+				log.Printf("Uplink changed: event=uplink_change af=%s old_interface=%s old_gateway=%s new_interface=%s new_gateway=%s",
+					af, routeInterface(prevUp), routeGateway(prevUp), routeInterface(curUp), routeGateway(curUp))
+			}
+		}
+	}
+	w.routes = byAF
+
+	defaultRouteInfo.Reset()
+	for af, rs := range byAF {
+		for i, r := range rs {
+			defaultRouteInfo.WithLabelValues(af, r.Interface, r.IPAddr().String(), strconv.Itoa(r.Metric), strconv.FormatBool(i == 0)).Set(1)
+		}
+	}
+}
+
+func routesEqual(a, b []route) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Interface != b[i].Interface || !a[i].Gateway.Equal(b[i].Gateway) || a[i].Metric != b[i].Metric {
+			return false
+		}
+	}
+	return true
+}
+
+// uplinkEqual returns whether the routes use the same interface and
+// gateway. Nil means there is no route.
+func uplinkEqual(a, b *route) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Interface == b.Interface && a.Gateway.Equal(b.Gateway)
+}
+
+func routeInterface(r *route) string {
+	if r == nil {
+		return "none"
+	}
+	return r.Interface
+}
+
+func routeGateway(r *route) string {
+	if r == nil {
+		return "none"
+	}
+	return r.IPAddr().String()
+}
@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// subscribeRouteChanges returns a channel that receives a value
+// whenever the kernel notifies about IPv4 or IPv6 route changes. The
+// channel is closed when the context is done, or reading fails.
+func subscribeRouteChanges(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "netlink")
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+
+		bs := make([]byte, 1<<16)
+		for {
+			// The content doesn't matter, since the routing tables
+			// are reread. A full socket buffer (ENOBUFS) also means
+			// something changed.
+			if _, err := f.Read(bs); err != nil && !errors.Is(err, unix.ENOBUFS) {
+				return
+			}
+
+			// Coalesce notifications the watcher hasn't handled yet.
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// subscribeRouteChanges always fails. Route change notifications are
+// only supported on Linux.
+func subscribeRouteChanges(ctx context.Context) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("route change notifications are not supported on this platform")
+}
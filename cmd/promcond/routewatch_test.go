@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRouteWatcherUpdate(t *testing.T) {
+	const header = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+	dir := t.TempDir()
+	defaultRouteInfo.Reset()
+	defaultRouteChanges.Reset()
+	uplinkChanges.Reset()
+
+	var w routeWatcher
+	withRouteFiles(t, dir,
+		header+
+			"eth0\t00000000\t010200C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"+
+			"wwan0\t00000000\t0100000A\t0003\t0\t0\t200\t00000000\t0\t0\t0\n",
+		"")
+	w.update()
+
+	if got := testutil.ToFloat64(defaultRouteChanges.WithLabelValues("ip4")); got != 0 {
+		t.Errorf("defaultRouteChanges after first update: got %v, want 0", got)
+	}
+
+	// The primary route goes away.
+	withRouteFiles(t, dir,
+		header+
+			"wwan0\t00000000\t0100000A\t0003\t0\t0\t200\t00000000\t0\t0\t0\n",
+		"")
+	w.update()
+
+	if got := testutil.ToFloat64(defaultRouteChanges.WithLabelValues("ip4")); got != 1 {
+		t.Errorf("defaultRouteChanges: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(uplinkChanges.WithLabelValues("ip4")); got != 1 {
+		t.Errorf("uplinkChanges: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(uplinkChanges.WithLabelValues("ip6")); got != 0 {
+		t.Errorf("uplinkChanges(ip6): got %v, want 0", got)
+	}
+
+	want := `
+# HELP connectivity_default_route_info A current default route of the instance. The primary route of each address family has primary="true".
+# TYPE connectivity_default_route_info gauge
+connectivity_default_route_info{af="ip4",gateway="10.0.0.1",interface="wwan0",metric="200",primary="true"} 1
+`
+	if err := testutil.CollectAndCompare(defaultRouteInfo, strings.NewReader(want)); err != nil {
+		t.Errorf("CollectAndCompare failed: %v", err)
+	}
+
+	// Only a secondary route is added.
+	withRouteFiles(t, dir,
+		header+
+			"wwan0\t00000000\t0100000A\t0003\t0\t0\t200\t00000000\t0\t0\t0\n"+
+			"eth0\t00000000\t010200C0\t0003\t0\t0\t300\t00000000\t0\t0\t0\n",
+		"")
+	w.update()
+
+	if got := testutil.ToFloat64(defaultRouteChanges.WithLabelValues("ip4")); got != 2 {
+		t.Errorf("defaultRouteChanges: got %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(uplinkChanges.WithLabelValues("ip4")); got != 1 {
+		t.Errorf("uplinkChanges: got %v, want 1", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronAliases are the named schedules understood by
+// parseCronSchedule.
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// A cronSchedule is a set of times of the format of crontab(5), in
+// local time. The fields are bit sets of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are whether the day fields were "*". Unless
+	// both are restricted, both must match. Otherwise either must.
+	domAny, dowAny bool
+}
+
+// parseCronSchedule parses five space-separated fields: minute, hour,
+// day of month, month and day of week. Fields are "*", numbers,
+// ranges "a-b" and steps "*/n" or "a-b/n", separated by commas. Day
+// of week 0 and 7 are Sunday.
+//
+//	0 2,14 * * 1-5
+func parseCronSchedule(s string) (*cronSchedule, error) {
+	if alias, ok := cronAliases[s]; ok {
+		s = alias
+	}
+	fs := strings.Fields(s)
+	if len(fs) != 5 {
+		return nil, fmt.Errorf("expected five fields in schedule: %q", s)
+	}
+
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fs[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule %q: %w", s, err)
+	}
+	if c.hour, err = parseCronField(fs[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule %q: %w", s, err)
+	}
+	if c.dom, err = parseCronField(fs[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in schedule %q: %w", s, err)
+	}
+	if c.month, err = parseCronField(fs[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule %q: %w", s, err)
+	}
+	if c.dow, err = parseCronField(fs[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in schedule %q: %w", s, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 << 0
+	}
+	c.domAny = fs[2] == "*"
+	c.dowAny = fs[4] == "*"
+	return &c, nil
+}
+
+// parseCronField returns the bit set of values in the field.
+func parseCronField(s string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step: %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			ss := strings.SplitN(rng, "-", 2)
+			lo, err = strconv.Atoi(ss[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %q", part)
+			}
+			hi = lo
+			if len(ss) == 2 {
+				hi, err = strconv.Atoi(ss[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid value: %q", part)
+				}
+			} else if step > 1 {
+				// "a/n" means from a to the end.
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range [%d, %d]: %q", min, max, part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time in the schedule after now, or the zero
+// time if there is none within five years.
+func (c *cronSchedule) next(now time.Time) time.Time {
+	loc := now.Location()
+	t := now.Add(time.Minute - time.Duration(now.Second())*time.Second - time.Duration(now.Nanosecond()))
+	end := now.AddDate(5, 0, 0)
+	for t.Before(end) {
+		y, m, d := t.Date()
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches returns whether the day fields match the date of t.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// A timeWindow is a daily time range, in local time. If End is before
+// Start, the window spans midnight.
+type timeWindow struct {
+	// Start and End are offsets from midnight. End is exclusive.
+	Start, End time.Duration
+}
+
+// parseTimeWindows parses comma-separated windows like "22:00-06:00".
+func parseTimeWindows(s string) ([]timeWindow, error) {
+	var ws []timeWindow
+	for _, part := range strings.Split(s, ",") {
+		ss := strings.SplitN(part, "-", 2)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("expected HH:MM-HH:MM in time window: %q", part)
+		}
+		start, err := parseTimeOfDay(ss[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(ss[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("empty time window: %q", part)
+		}
+		ws = append(ws, timeWindow{Start: start, End: end})
+	}
+	return ws, nil
+}
+
+// parseTimeOfDay parses "HH:MM" as an offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns whether t is inside the window.
+func (w timeWindow) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	tod := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// allowedAt returns whether the time windows of the check allow it to
+// run at t. Without allowed windows, all times are allowed, except
+// the blocked windows.
+func (chk *ConnectivityCheck) allowedAt(t time.Time) bool {
+	ok := len(chk.Allow) == 0
+	for _, w := range chk.Allow {
+		if w.contains(t) {
+			ok = true
+			break
+		}
+	}
+	for _, w := range chk.Block {
+		if w.contains(t) {
+			return false
+		}
+	}
+	return ok
+}
+
+// nextAllowed returns the first time at or after t that the time
+// windows of the check allow, or the zero time if there is none.
+func (chk *ConnectivityCheck) nextAllowed(t time.Time) time.Time {
+	if chk.allowedAt(t) {
+		return t
+	}
+
+	// Whether a time is allowed only changes at window boundaries,
+	// and the windows repeat daily.
+	var best time.Time
+	y, m, d := t.Date()
+	for day := 0; day <= 2; day++ {
+		midnight := time.Date(y, m, d+day, 0, 0, 0, 0, t.Location())
+		for _, ws := range [][]timeWindow{chk.Allow, chk.Block} {
+			for _, w := range ws {
+				for _, off := range []time.Duration{w.Start, w.End} {
+					b := midnight.Add(off)
+					if b.After(t) && (best.IsZero() || b.Before(best)) && chk.allowedAt(b) {
+						best = b
+					}
+				}
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tsts := []struct {
+		Name    string
+		In      string
+		Want    cronSchedule
+		WantErr string
+	}{
+		{"stars", "* * * * *", cronSchedule{minute: 1<<60 - 1, hour: 1<<24 - 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1<<8 - 1, domAny: true, dowAny: true}, ""},
+		{"lists", "0,30 2,14 1 6 *", cronSchedule{minute: 1 | 1<<30, hour: 1<<2 | 1<<14, dom: 1 << 1, month: 1 << 6, dow: 1<<8 - 1, dowAny: true}, ""},
+		{"ranges", "0 0-2 * * 1-5", cronSchedule{minute: 1, hour: 7, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 0x3E, domAny: true}, ""},
+		{"steps", "*/20 1-5/2 * * 5/2", cronSchedule{minute: 1 | 1<<20 | 1<<40, hour: 1<<1 | 1<<3 | 1<<5, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1 | 1<<5 | 1<<7, domAny: true}, ""},
+		{"sunday7", "0 0 * * 7", cronSchedule{minute: 1, hour: 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1 | 1<<7, domAny: true}, ""},
+		{"alias", "@daily", cronSchedule{minute: 1, hour: 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1<<8 - 1, domAny: true, dowAny: true}, ""},
+
+		{"fields", "* * * *", cronSchedule{}, "expected five fields"},
+		{"range", "60 * * * *", cronSchedule{}, "invalid minute"},
+		{"reversed", "* 5-2 * * *", cronSchedule{}, "invalid hour"},
+		{"step", "*/0 * * * *", cronSchedule{}, "invalid step"},
+		{"value", "* * x * *", cronSchedule{}, "invalid day of month"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			got, err := parseCronSchedule(tst.In)
+			if tst.WantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tst.WantErr) {
+					t.Fatalf("parseCronSchedule(%q) err: got %v, want %q", tst.In, err, tst.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCronSchedule(%q) failed: %v", tst.In, err)
+			}
+			if *got != tst.Want {
+				t.Errorf("parseCronSchedule(%q): got %+v, want %+v", tst.In, *got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2021, 3, 3, 10, 17, 30, 0, time.UTC)
+	tsts := []struct {
+		In   string
+		Want time.Time
+	}{
+		{"* * * * *", time.Date(2021, 3, 3, 10, 18, 0, 0, time.UTC)},
+		{"17 * * * *", time.Date(2021, 3, 3, 11, 17, 0, 0, time.UTC)},
+		{"0 2,14 * * *", time.Date(2021, 3, 3, 14, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2021, 3, 4, 2, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches if both are restricted.
+		{"0 0 15 * 5", time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tst := range tsts {
+		c, err := parseCronSchedule(tst.In)
+		if err != nil {
+			t.Fatalf("parseCronSchedule(%q) failed: %v", tst.In, err)
+		}
+		if got := c.next(now); !got.Equal(tst.Want) {
+			t.Errorf("next(%q): got %v, want %v", tst.In, got, tst.Want)
+		}
+	}
+}
+
+func TestParseTimeWindows(t *testing.T) {
+	got, err := parseTimeWindows("22:00-06:00,12:30-13:00")
+	if err != nil {
+		t.Fatalf("parseTimeWindows failed: %v", err)
+	}
+	want := []timeWindow{{22 * time.Hour, 6 * time.Hour}, {12*time.Hour + 30*time.Minute, 13 * time.Hour}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parseTimeWindows: got %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"22:00", "22:00-25:00", "10:00-10:00"} {
+		if _, err := parseTimeWindows(s); err == nil {
+			t.Errorf("parseTimeWindows(%q): got nil error, want error", s)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	at := func(h, m int) time.Time {
+		return time.Date(2021, 3, 3, h, m, 0, 0, time.Local)
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		chk := ConnectivityCheck{Kind: KindTransfer, Host: "a", Service: "b", Interval: time.Hour, Allow: []timeWindow{{22 * time.Hour, 6 * time.Hour}}}
+		got := chk.nextRun(at(10, 0))
+		if !chk.allowedAt(got) {
+			t.Errorf("nextRun: got %v, want allowed", got)
+		}
+		if got.Before(at(22, 0)) || !got.Before(at(23, 0)) {
+			t.Errorf("nextRun: got %v, want in the first hour of the window", got)
+		}
+		if got, want := time.Duration(got.UnixNano()%int64(chk.Interval)), chk.phase(); got != want {
+			t.Errorf("nextRun: got phase %v, want %v", got, want)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		sched, err := parseCronSchedule("0 * * * *")
+		if err != nil {
+			t.Fatalf("parseCronSchedule failed: %v", err)
+		}
+		chk := ConnectivityCheck{Schedule: sched, Block: []timeWindow{{8 * time.Hour, 18 * time.Hour}}}
+		if got, want := chk.nextRun(at(7, 30)), at(18, 0); !got.Equal(want) {
+			t.Errorf("nextRun: got %v, want %v", got, want)
+		}
+		if got, want := chk.nextRun(at(18, 30)), at(19, 0); !got.Equal(want) {
+			t.Errorf("nextRun: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("never", func(t *testing.T) {
+		chk := ConnectivityCheck{Interval: time.Hour, Allow: []timeWindow{{1 * time.Hour, 2 * time.Hour}}, Block: []timeWindow{{0, 3 * time.Hour}}}
+		if got := chk.nextRun(at(10, 0)); !got.IsZero() {
+			t.Errorf("nextRun: got %v, want zero", got)
+		}
+	})
+}
+
+func mustParseCronSchedule(s string) *cronSchedule {
+	c, err := parseCronSchedule(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
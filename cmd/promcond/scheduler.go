@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var checkQueueWait *prometheus.HistogramVec
+
+func init() {
+	registerCheckVecs(func(keys []string) []prometheus.Collector {
+		checkQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "connectivity",
+			Name:      "check_queue_wait",
+			Help:      "Time a check waited for other checks to finish before running, in seconds.",
+			Buckets:   []float64{0.001, 0.01, 0.1, 1, 5, 10, 30, 60, 5 * 60},
+		}, labelNames(serviceLabelNames, keys))
+
+		return []prometheus.Collector{checkQueueWait}
+	})
+}
+
+// A scheduler limits how many checks run concurrently. Exclusive
+// checks run alone, so they don't distort other measurements, nor are
+// distorted by them. Checks are admitted in the order they arrive, so
+// exclusive checks aren't starved by a steady stream of others.
+type scheduler struct {
+	// limit is the maximum number of concurrently running checks. Zero
+	// means no limit.
+	limit int
+
+	mu        sync.Mutex
+	running   int
+	exclusive bool
+	queue     []*schedulerWaiter
+}
+
+// A schedulerWaiter is a check waiting to run.
+type schedulerWaiter struct {
+	exclusive bool
+
+	// admitted is closed when the check may run.
+	admitted chan struct{}
+}
+
+// checkScheduler is the scheduler of all running checks.
+var checkScheduler = newScheduler(0)
+
+func newScheduler(limit int) *scheduler {
+	return &scheduler{limit: limit}
+}
+
+// acquire waits until a check may run. The returned function must be
+// called when it has finished.
+func (s *scheduler) acquire(ctx context.Context, exclusive bool) (func(), error) {
+	w := &schedulerWaiter{exclusive: exclusive, admitted: make(chan struct{})}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, w)
+	s.admit()
+	s.mu.Unlock()
+
+	select {
+	case <-w.admitted:
+		return func() { s.release(w) }, nil
+
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for i, qw := range s.queue {
+			if qw == w {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				// The head may have changed.
+				s.admit()
+				return nil, ctx.Err()
+			}
+		}
+		// Admitted concurrently with the cancellation.
+		s.releaseLocked(w)
+		return nil, ctx.Err()
+	}
+}
+
+// release marks a check as finished.
+func (s *scheduler) release(w *schedulerWaiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseLocked(w)
+}
+
+func (s *scheduler) releaseLocked(w *schedulerWaiter) {
+	s.running--
+	if w.exclusive {
+		s.exclusive = false
+	}
+	s.admit()
+}
+
+// admit lets waiting checks run, in order, as long as the head of the
+// queue can run. s.mu must be held.
+func (s *scheduler) admit() {
+	for len(s.queue) > 0 {
+		w := s.queue[0]
+		if w.exclusive {
+			if s.running > 0 {
+				return
+			}
+		} else if s.exclusive || (s.limit > 0 && s.running >= s.limit) {
+			return
+		}
+
+		s.queue = s.queue[1:]
+		s.running++
+		s.exclusive = w.exclusive
+		close(w.admitted)
+	}
+}
+
+// exclusive returns whether the check uses enough bandwidth that it
+// should run alone.
+func (chk *ConnectivityCheck) exclusive() bool {
+	return chk.Kind == KindTransfer || chk.Kind == KindHostFloodPing
+}
+
+// waitForTurn acquires a slot in checkScheduler for the check, and
+// records how long it took.
+func waitForTurn(ctx context.Context, chk *ConnectivityCheck) (func(), error) {
+	start := time.Now()
+	release, err := checkScheduler.acquire(ctx, chk.exclusive())
+	if err != nil {
+		return nil, err
+	}
+	checkQueueWait.WithLabelValues(chk.serviceLabels()...).Observe(time.Since(start).Seconds())
+	return release, nil
+}
+
+// phase returns the offset of the check's runs within its interval,
+// relative to the Unix epoch. It's the phase assigned by spreadPhases,
+// or hashPhase.
+func (chk *ConnectivityCheck) phase() time.Duration {
+	if chk.phaseSpread {
+		return chk.spreadPhase
+	}
+	return chk.hashPhase()
+}
+
+// hashPhase returns a phase derived from a hash of the check's
+// identity, so it's stable across restarts.
+func (chk *ConnectivityCheck) hashPhase() time.Duration {
+	if chk.Interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join([]string{chk.Network, chk.Host, chk.Service, chk.Kind.String(), chk.Socket.Interface, chk.Name}, "\x00")))
+	return time.Duration(h.Sum64() % uint64(chk.Interval))
+}
+
+// spreadPhases assigns phases to checks that share an interval, so
+// they're spread evenly across it. They're ordered by hashPhase, so
+// the phases are stable as long as the checks don't change.
+func spreadPhases(checks []ConnectivityCheck) {
+	byInterval := map[time.Duration][]*ConnectivityCheck{}
+	for i := range checks {
+		chk := &checks[i]
+		if chk.Schedule != nil || chk.Interval <= 0 {
+			continue
+		}
+		byInterval[chk.Interval] = append(byInterval[chk.Interval], chk)
+	}
+
+	for interval, chks := range byInterval {
+		sort.SliceStable(chks, func(i, j int) bool {
+			return chks[i].hashPhase() < chks[j].hashPhase()
+		})
+		for i, chk := range chks {
+			chk.spreadPhase = interval * time.Duration(i) / time.Duration(len(chks))
+			chk.phaseSpread = true
+		}
+	}
+}
+
+// phaseDelay returns how long to wait from now until the check's next
+// run at its phase.
+func (chk *ConnectivityCheck) phaseDelay(now time.Time) time.Duration {
+	if chk.Interval <= 0 {
+		return 0
+	}
+	d := (chk.phase() - time.Duration(now.UnixNano()%int64(chk.Interval))) % chk.Interval
+	if d < 0 {
+		d += chk.Interval
+	}
+	return d
+}
+
+// maxWindowSteps bounds the search for a run that the time windows
+// allow.
+const maxWindowSteps = 100
+
+// nextRun returns the first time after now that the check should
+// run, or the zero time if its time windows never allow it. The
+// candidates are the times of the schedule, or the check's phase.
+func (chk *ConnectivityCheck) nextRun(now time.Time) time.Time {
+	t := chk.nextSlot(now)
+	for i := 0; i < maxWindowSteps && !t.IsZero(); i++ {
+		a := chk.nextAllowed(t)
+		if a.Equal(t) || a.IsZero() {
+			return a
+		}
+		// The first slot at or after a.
+		t = chk.nextSlot(a.Add(-time.Nanosecond))
+	}
+	return time.Time{}
+}
+
+// nextSlot returns the first time after now in the schedule, or at
+// the phase, ignoring time windows.
+func (chk *ConnectivityCheck) nextSlot(now time.Time) time.Time {
+	if chk.Schedule != nil {
+		return chk.Schedule.next(now)
+	}
+	d := chk.phaseDelay(now)
+	if d == 0 {
+		d = chk.Interval
+	}
+	return now.Add(d)
+}
+
+// nextAttempt returns when to run the check after a run that ended at
+// now, given the number of consecutive failed runs, and the interval
+// until then. The time is zero if the check will not run again.
+func (chk *ConnectivityCheck) nextAttempt(now time.Time, failures int) (time.Time, time.Duration) {
+	// Runs that take longer than the interval skip phases, but the
+	// pace is kept.
+	at := chk.nextRun(now)
+	interval := chk.Interval
+	if chk.Schedule != nil && !at.IsZero() {
+		interval = at.Sub(now)
+	}
+	if d := chk.retryInterval(failures); d > 0 {
+		if r := now.Add(d); (at.IsZero() || r.Before(at)) && chk.allowedAt(r) {
+			return r, d
+		}
+	}
+	return at, interval
+}
+
+// retryInterval returns how long to wait after the given number of
+// consecutive failed runs, or zero if the check should wait for its
+// next run. The backoff is limited by the interval, or a day for
+// scheduled checks.
+func (chk *ConnectivityCheck) retryInterval(failures int) time.Duration {
+	if failures == 0 || chk.FailInterval <= 0 {
+		return 0
+	}
+	limit := chk.Interval
+	if limit <= 0 {
+		limit = 24 * time.Hour
+	}
+	d := chk.FailInterval
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	if d >= limit {
+		return 0
+	}
+	return d
+}
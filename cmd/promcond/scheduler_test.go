@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("limit", func(t *testing.T) {
+		s := newScheduler(2)
+		r1 := mustAcquire(t, s, false)
+		mustAcquire(t, s, false)
+
+		ch := acquireAsync(s, false)
+		assertWaiting(t, ch)
+
+		r1()
+		assertAdmitted(t, ch)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		s := newScheduler(0)
+		for i := 0; i < 100; i++ {
+			mustAcquire(t, s, false)
+		}
+	})
+
+	t.Run("exclusiveWaitsForOthers", func(t *testing.T) {
+		s := newScheduler(0)
+		r1 := mustAcquire(t, s, false)
+
+		ch := acquireAsync(s, true)
+		assertWaiting(t, ch)
+
+		r1()
+		assertAdmitted(t, ch)
+	})
+
+	t.Run("othersWaitForExclusive", func(t *testing.T) {
+		s := newScheduler(0)
+		r1 := mustAcquire(t, s, true)
+
+		ch := acquireAsync(s, false)
+		assertWaiting(t, ch)
+
+		r1()
+		assertAdmitted(t, ch)
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		s := newScheduler(0)
+		r1 := mustAcquire(t, s, false)
+
+		ex := acquireAsync(s, true)
+		assertWaiting(t, ex)
+
+		// Would be allowed by the limit, but must not overtake the
+		// exclusive check.
+		ch := acquireAsync(s, false)
+		assertWaiting(t, ch)
+
+		r1()
+		r2 := <-ex
+		assertWaiting(t, ch)
+
+		r2()
+		assertAdmitted(t, ch)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		s := newScheduler(1)
+		r1 := mustAcquire(t, s, false)
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := s.acquire(ctx, true); err != context.Canceled {
+			t.Fatalf("acquire: got %v, want %v", err, context.Canceled)
+		}
+		if len(s.queue) != 0 {
+			t.Errorf("queue: got %d waiters, want none", len(s.queue))
+		}
+
+		r1()
+		mustAcquire(t, s, false)
+	})
+}
+
+func mustAcquire(t *testing.T, s *scheduler, exclusive bool) func() {
+	t.Helper()
+
+	release, err := s.acquire(context.Background(), exclusive)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	return release
+}
+
+// acquireAsync acquires in the background, and returns once the
+// check is queued. It must only be used when the check has to wait.
+func acquireAsync(s *scheduler, exclusive bool) <-chan func() {
+	s.mu.Lock()
+	n := len(s.queue)
+	s.mu.Unlock()
+
+	ch := make(chan func(), 1)
+	go func() {
+		release, _ := s.acquire(context.Background(), exclusive)
+		ch <- release
+	}()
+
+	for {
+		s.mu.Lock()
+		queued := len(s.queue) > n
+		s.mu.Unlock()
+		if queued {
+			return ch
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func assertWaiting(t *testing.T, ch <-chan func()) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatalf("acquire: admitted, want waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func assertAdmitted(t *testing.T, ch <-chan func()) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire: waiting, want admitted")
+	}
+}
+
+func TestPhase(t *testing.T) {
+	chk := ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: time.Minute}
+	other := chk
+	other.Host = "b"
+
+	if got, want := chk.phase(), chk.phase(); got != want {
+		t.Errorf("phase: got %v, want stable %v", got, want)
+	}
+	if got := chk.phase(); got < 0 || got >= chk.Interval {
+		t.Errorf("phase: got %v, want within [0, %v)", got, chk.Interval)
+	}
+	if chk.phase() == other.phase() {
+		t.Errorf("phase: got %v for both checks, want different", chk.phase())
+	}
+
+	for _, now := range []time.Time{time.Unix(0, 0), time.Unix(1234, 5678), time.Now()} {
+		d := chk.phaseDelay(now)
+		if d < 0 || d >= chk.Interval {
+			t.Errorf("phaseDelay(%v): got %v, want within [0, %v)", now, d, chk.Interval)
+		}
+		if got, want := time.Duration(now.Add(d).UnixNano()%int64(chk.Interval)), chk.phase(); got != want {
+			t.Errorf("phaseDelay(%v): got phase %v, want %v", now, got, want)
+		}
+	}
+}
+
+func TestSpreadPhases(t *testing.T) {
+	checks := []ConnectivityCheck{
+		{Kind: KindHostPing, Network: "ip", Host: "a", Interval: time.Minute},
+		{Kind: KindHostPing, Network: "ip", Host: "b", Interval: time.Minute},
+		{Kind: KindHostPing, Network: "ip", Host: "c", Interval: time.Minute},
+		{Kind: KindHostPing, Network: "ip", Host: "d", Interval: time.Minute},
+		{Kind: KindHostPing, Network: "ip", Host: "e", Interval: time.Hour},
+	}
+	spreadPhases(checks)
+
+	got := map[time.Duration]bool{}
+	for _, chk := range checks[:4] {
+		got[chk.phase()] = true
+	}
+	for _, want := range []time.Duration{0, 15 * time.Second, 30 * time.Second, 45 * time.Second} {
+		if !got[want] {
+			t.Errorf("phase: got %v, want one at %v", got, want)
+		}
+	}
+	if got := checks[4].phase(); got != 0 {
+		t.Errorf("phase of the only hourly check: got %v, want 0", got)
+	}
+
+	again := []ConnectivityCheck{checks[3], checks[1], checks[0], checks[2]}
+	for i := range again {
+		again[i].phaseSpread = false
+	}
+	spreadPhases(again)
+	for i, j := range []int{3, 1, 0, 2} {
+		if got, want := again[i].phase(), checks[j].phase(); got != want {
+			t.Errorf("phase of %s after reordering: got %v, want stable %v", again[i].Host, got, want)
+		}
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	now := time.Date(2021, 3, 3, 10, 0, 0, 0, time.UTC)
+	chk := ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "a", Interval: time.Minute, FailInterval: 10 * time.Second}
+	chk.spreadPhase, chk.phaseSpread = 30*time.Second, true
+
+	tsts := []struct {
+		Failures     int
+		Want         time.Time
+		WantInterval time.Duration
+	}{
+		{0, now.Add(30 * time.Second), time.Minute},
+		{1, now.Add(10 * time.Second), 10 * time.Second},
+		{2, now.Add(20 * time.Second), 20 * time.Second},
+		// The next run comes before the retry.
+		{3, now.Add(30 * time.Second), time.Minute},
+		// Backed off to the interval.
+		{4, now.Add(30 * time.Second), time.Minute},
+	}
+	for _, tst := range tsts {
+		at, interval := chk.nextAttempt(now, tst.Failures)
+		if !at.Equal(tst.Want) || interval != tst.WantInterval {
+			t.Errorf("nextAttempt(%v, %d): got %v, %v, want %v, %v", now, tst.Failures, at, interval, tst.Want, tst.WantInterval)
+		}
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		chk := chk
+		// Retries at 10:00:10 are blocked, so it waits for the run
+		// after the window.
+		chk.Block = []timeWindow{{10 * time.Hour, 10*time.Hour + 20*time.Second}}
+		at, _ := chk.nextAttempt(now, 1)
+		if want := now.Add(30 * time.Second); !at.Equal(want) {
+			t.Errorf("nextAttempt: got %v, want %v", at, want)
+		}
+	})
+}
+
+func TestRetryInterval(t *testing.T) {
+	chk := ConnectivityCheck{Interval: time.Minute, FailInterval: 10 * time.Second}
+	tsts := []struct {
+		Failures int
+		Want     time.Duration
+	}{
+		{0, 0},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 0},
+		{100, 0},
+	}
+	for _, tst := range tsts {
+		if got := chk.retryInterval(tst.Failures); got != tst.Want {
+			t.Errorf("retryInterval(%d): got %v, want %v", tst.Failures, got, tst.Want)
+		}
+	}
+
+	chk.FailInterval = 0
+	if got := chk.retryInterval(1); got != 0 {
+		t.Errorf("retryInterval(1) without FailInterval: got %v, want 0", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// icmpPrivileged makes ping checks use raw ICMP sockets, which need
+// CAP_NET_RAW, instead of unprivileged ICMP datagram sockets.
+var icmpPrivileged = false
+
+// SocketOptions control how the sockets of a check are opened. The
+// zero value uses the defaults of the host.
+type SocketOptions struct {
+	// Interface binds sockets to a network interface, so the check
+	// only uses routes through it.
+	Interface string
+
+	// Source is the local address to send from.
+	Source net.IP
+
+	// Mark is the firewall mark (fwmark) of outgoing packets, for
+	// policy routing. Zero means no mark.
+	Mark uint32
+
+	// Netns is the named network namespace, as created by
+	// ip-netns(8), or a path to a namespace file, to open sockets in.
+	Netns string
+}
+
+// dialer returns a stream dialer using the options.
+func (so SocketOptions) dialer() *socketDialer {
+	d := &socketDialer{netns: so.Netns}
+	d.Control = so.control
+	if so.Source != nil {
+		d.LocalAddr = &net.TCPAddr{IP: so.Source}
+	}
+	return d
+}
+
+// A socketDialer is a net.Dialer that dials in a network namespace.
+type socketDialer struct {
+	net.Dialer
+
+	netns string
+}
+
+func (d *socketDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var conn net.Conn
+	err := inNetns(d.netns, func() error {
+		var err error
+		conn, err = d.Dialer.DialContext(ctx, network, address)
+		return err
+	})
+	return conn, err
+}
+
+// checkSourceNetwork returns an error if the source address can't be
+// used with the network, one of "ip4" and "ip6".
+func (so SocketOptions) checkSourceNetwork(network string) error {
+	if so.Source != nil && !networkMatchesIP(network, so.Source) {
+		return fmt.Errorf("source address %v is not usable with network %s", so.Source, network)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// control applies the options to a socket before it's connected. It
+// is used as net.Dialer.Control.
+func (so SocketOptions) control(network, address string, c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = so.setsockopt(int(fd))
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+func (so SocketOptions) setsockopt(fd int) error {
+	if so.Interface != "" {
+		if err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, so.Interface); err != nil {
+			return fmt.Errorf("binding to interface %s: %w", so.Interface, err)
+		}
+	}
+	if so.Mark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(so.Mark)); err != nil {
+			return fmt.Errorf("setting mark %d: %w", so.Mark, err)
+		}
+	}
+	return nil
+}
+
+// netnsDir is where ip-netns(8) keeps named network namespaces. It's
+// a test injection point.
+var netnsDir = "/var/run/netns"
+
+// inNetns runs f on an OS thread that has entered the network
+// namespace. Sockets created by f stay in the namespace. An empty
+// name runs f directly.
+func inNetns(name string, f func() error) error {
+	if name == "" {
+		return f()
+	}
+
+	path := name
+	if !strings.ContainsRune(name, '/') {
+		path = filepath.Join(netnsDir, name)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked. This makes the runtime
+		// terminate it when the goroutine exits, instead of
+		// reusing a thread in the wrong namespace.
+		runtime.LockOSThread()
+
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			errCh <- fmt.Errorf("opening network namespace %s: %w", name, err)
+			return
+		}
+		defer unix.Close(fd)
+		if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+			errCh <- fmt.Errorf("entering network namespace %s: %w", name, err)
+			return
+		}
+
+		errCh <- f()
+	}()
+	return <-errCh
+}
+
+// listenICMP opens an unprivileged ICMP datagram socket for the
+// network, one of "ip4" and "ip6". This requires the group to be in
+// the net.ipv4.ping_group_range sysctl. If icmpPrivileged, it opens a
+// raw socket instead.
+func listenICMP(network string, so SocketOptions) (net.PacketConn, error) {
+	if err := so.checkSourceNetwork(network); err != nil {
+		return nil, err
+	}
+
+	var conn net.PacketConn
+	err := inNetns(so.Netns, func() error {
+		var err error
+		conn, err = listenICMPHere(network, so)
+		return err
+	})
+	return conn, err
+}
+
+// listenICMPHere is listenICMP in the current network namespace.
+func listenICMPHere(network string, so SocketOptions) (net.PacketConn, error) {
+	family, proto := unix.AF_INET, unix.IPPROTO_ICMP
+	if network == "ip6" {
+		family, proto = unix.AF_INET6, unix.IPPROTO_ICMPV6
+	}
+	sotype := unix.SOCK_DGRAM
+	if icmpPrivileged {
+		sotype = unix.SOCK_RAW
+	}
+	fd, err := unix.Socket(family, sotype|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+
+	if err := so.setsockopt(fd); err != nil {
+		return nil, err
+	}
+	if so.Source != nil {
+		var sa unix.Sockaddr
+		if ip4 := so.Source.To4(); ip4 != nil {
+			sa4 := &unix.SockaddrInet4{}
+			copy(sa4.Addr[:], ip4)
+			sa = sa4
+		} else {
+			sa6 := &unix.SockaddrInet6{}
+			copy(sa6.Addr[:], so.Source.To16())
+			sa = sa6
+		}
+		if err := unix.Bind(fd, sa); err != nil {
+			return nil, fmt.Errorf("binding to source %v: %w", so.Source, err)
+		}
+	}
+
+	// This duplicates the file descriptor.
+	return net.FilePacketConn(f)
+}
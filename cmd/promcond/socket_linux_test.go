@@ -0,0 +1,127 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocketOptionsNetns(t *testing.T) {
+	ns := setUpTestNetns(t)
+	ctx := context.Background()
+
+	pi := pingInterval
+	pingInterval = 10 * time.Millisecond
+	defer func() {
+		pingInterval = pi
+	}()
+
+	t.Run("ping", func(t *testing.T) {
+		got, err := checker{}.CheckPing(ctx, "ip4", "198.51.100.1", false, SocketOptions{Netns: ns})
+		if err != nil {
+			t.Fatalf("CheckPing failed: %v", err)
+		}
+		if got.PacketsRecv != got.PacketsSent {
+			t.Errorf("CheckPing PacketsRecv: got %v, want %v", got.PacketsRecv, got.PacketsSent)
+		}
+	})
+
+	t.Run("pingInterface", func(t *testing.T) {
+		got, err := checker{}.CheckPing(ctx, "ip4", "198.51.100.1", false, SocketOptions{Interface: "pctest1", Netns: ns})
+		if err != nil {
+			t.Fatalf("CheckPing failed: %v", err)
+		}
+		if got.PacketsRecv != got.PacketsSent {
+			t.Errorf("CheckPing PacketsRecv: got %v, want %v", got.PacketsRecv, got.PacketsSent)
+		}
+	})
+
+	t.Run("pingMark", func(t *testing.T) {
+		// The namespace prohibits routing of marked packets.
+		if _, err := (checker{}).CheckPing(ctx, "ip4", "198.51.100.1", false, SocketOptions{Mark: 42, Netns: ns}); err == nil {
+			t.Errorf("CheckPing err: got %v, want non-nil", err)
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		// The listener is only reachable from inside the namespace.
+		var l net.Listener
+		if err := inNetns(ns, func() error {
+			var err error
+			l, err = net.Listen("tcp4", "127.0.0.1:0")
+			return err
+		}); err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		defer l.Close()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}()
+		taddr := l.Addr().(*net.TCPAddr)
+
+		if _, err := (checker{}).CheckConnect(ctx, "ip4", taddr.IP.String(), fmt.Sprint(taddr.Port), SocketOptions{Netns: ns}); err != nil {
+			t.Errorf("CheckConnect failed: %v", err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if err := inNetns("promcond-does-not-exist", func() error { return nil }); err == nil {
+			t.Errorf("inNetns err: got %v, want non-nil", err)
+		}
+	})
+}
+
+// setUpTestNetns creates a network namespace, connected to the host
+// namespace through a veth pair. The host end is 198.51.100.1 and
+// 2001:db8::1, and the namespace end is 198.51.100.2 and
+// 2001:db8::2. Packets with mark 42 are
+// prohibited in the namespace. Returns the name of the namespace.
+func setUpTestNetns(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("Network namespace tests require root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("Network namespace tests require ip(8)")
+	}
+
+	ns := fmt.Sprintf("promcond-test-%d", os.Getpid())
+	cmds := [][]string{
+		{"netns", "add", ns},
+		{"link", "add", "pctest0", "type", "veth", "peer", "name", "pctest1"},
+		{"link", "set", "pctest1", "netns", ns},
+		{"addr", "add", "198.51.100.1/30", "dev", "pctest0"},
+		{"link", "set", "pctest0", "up"},
+		{"-n", ns, "addr", "add", "198.51.100.2/30", "dev", "pctest1"},
+		{"-n", ns, "link", "set", "pctest1", "up"},
+		{"addr", "add", "2001:db8::1/64", "dev", "pctest0", "nodad"},
+		{"-n", ns, "addr", "add", "2001:db8::2/64", "dev", "pctest1", "nodad"},
+		{"-n", ns, "link", "set", "lo", "up"},
+		{"-n", ns, "rule", "add", "fwmark", "42", "prohibit"},
+		{"netns", "exec", ns, "sh", "-c", "echo 0 2147483647 >/proc/sys/net/ipv4/ping_group_range"},
+	}
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", "pctest0").Run()
+		exec.Command("ip", "netns", "del", ns).Run()
+	})
+	for _, args := range cmds {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Skipf("Setting up network namespace failed: ip %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	return ns
+}
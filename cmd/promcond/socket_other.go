@@ -0,0 +1,65 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+)
+
+// control applies the options to a socket before it's connected. It
+// is used as net.Dialer.Control. Only Linux supports the options.
+func (so SocketOptions) control(network, address string, c syscall.RawConn) error {
+	return so.checkSupported()
+}
+
+// checkSupported returns an error if the options need Linux.
+func (so SocketOptions) checkSupported() error {
+	switch {
+	case so.Interface != "":
+		return fmt.Errorf("binding to interfaces is not supported on this platform")
+	case so.Mark != 0:
+		return fmt.Errorf("socket marks are not supported on this platform")
+	case so.Netns != "":
+		return fmt.Errorf("network namespaces are not supported on this platform")
+	default:
+		return nil
+	}
+}
+
+// inNetns runs f. Network namespaces are only supported on Linux.
+func inNetns(name string, f func() error) error {
+	if name != "" {
+		return fmt.Errorf("network namespaces are not supported on this platform")
+	}
+	return f()
+}
+
+// listenICMP opens an unprivileged ICMP datagram socket for the
+// network, one of "ip4" and "ip6". If icmpPrivileged, it opens a raw
+// socket instead.
+func listenICMP(network string, so SocketOptions) (net.PacketConn, error) {
+	if err := so.checkSourceNetwork(network); err != nil {
+		return nil, err
+	}
+	if err := so.checkSupported(); err != nil {
+		return nil, err
+	}
+
+	var src string
+	if so.Source != nil {
+		src = so.Source.String()
+	}
+	if icmpPrivileged {
+		proto := "ip4:icmp"
+		if network == "ip6" {
+			proto = "ip6:ipv6-icmp"
+		}
+		return icmp.ListenPacket(proto, src)
+	}
+	return icmp.ListenPacket(transportForNetwork(network, UnknownKind), src)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of staleAction.
+const (
+	staleDelete = "delete"
+	staleNaN    = "nan"
+)
+
+var (
+	// staleAfterRuns is how many consecutive runs of a check that
+	// didn't update a measurement make it stale. Zero disables it.
+	staleAfterRuns = 3
+
+	// staleMaxAge is how old a measurement may become before it's
+	// stale. Zero disables it.
+	staleMaxAge time.Duration
+
+	// staleAction is what happens to stale series: staleDelete or
+	// staleNaN.
+	staleAction = staleDelete
+)
+
+// parseStaleAction returns the action named by s.
+func parseStaleAction(s string) (string, error) {
+	switch s {
+	case staleDelete, staleNaN:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown stale action %q, expected %s or %s", s, staleDelete, staleNaN)
+	}
+}
+
+// A measuredSeries is a gauge series set by a check run.
+type measuredSeries struct {
+	vec    *prometheus.GaugeVec
+	labels []string
+
+	setAt time.Time
+	// fresh is whether the series was set by the current run.
+	fresh bool
+	// missed is how many runs since the series was last set.
+	missed  int
+	expired bool
+}
+
+// A seriesKey identifies a series of a vector.
+type seriesKey struct {
+	vec    *prometheus.GaugeVec
+	labels string
+}
+
+// A seriesTracker remembers the measurement gauges each check has
+// set, and expires them when the check stops updating them.
+type seriesTracker struct {
+	mu     sync.Mutex
+	checks map[*ConnectivityCheck]map[seriesKey]*measuredSeries
+}
+
+// measurements tracks the series of all running checks.
+var measurements = newSeriesTracker()
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{checks: map[*ConnectivityCheck]map[seriesKey]*measuredSeries{}}
+}
+
+// setGauge sets a measurement gauge of the check and tracks it for
+// expiry.
+func setGauge(chk *ConnectivityCheck, vec *prometheus.GaugeVec, labels []string, v float64) {
+	vec.WithLabelValues(labels...).Set(v)
+	measurements.set(chk, vec, labels, time.Now())
+}
+
+// set records that the series was set at now.
+func (st *seriesTracker) set(chk *ConnectivityCheck, vec *prometheus.GaugeVec, labels []string, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ss := st.checks[chk]
+	if ss == nil {
+		ss = map[seriesKey]*measuredSeries{}
+		st.checks[chk] = ss
+	}
+	key := seriesKey{vec, strings.Join(labels, "\x00")}
+	s := ss[key]
+	if s == nil {
+		s = &measuredSeries{vec: vec, labels: labels}
+		ss[key] = s
+	}
+	s.setAt = now
+	s.fresh = true
+	s.missed = 0
+	s.expired = false
+}
+
+// endRun expires the series of the check that are stale at now. If
+// ran, the check ran, and series it didn't set missed a run.
+// Otherwise, it was skipped or paused, and only staleMaxAge applies.
+func (st *seriesTracker) endRun(chk *ConnectivityCheck, now time.Time, ran bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, s := range st.checks[chk] {
+		if s.fresh {
+			s.fresh = false
+			continue
+		}
+		if ran {
+			s.missed++
+		}
+		if s.expired || !s.stale(now) {
+			continue
+		}
+
+		switch staleAction {
+		case staleNaN:
+			s.vec.WithLabelValues(s.labels...).Set(math.NaN())
+			s.expired = true
+		default:
+			s.vec.DeleteLabelValues(s.labels...)
+			delete(st.checks[chk], key)
+		}
+	}
+}
+
+// stale returns whether the series is stale at now.
+func (s *measuredSeries) stale(now time.Time) bool {
+	return staleAfterRuns > 0 && s.missed >= staleAfterRuns ||
+		staleMaxAge > 0 && now.Sub(s.setAt) >= staleMaxAge
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-ping/ping"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesTracker(t *testing.T) {
+	defer func(runs int, age time.Duration, action string) {
+		staleAfterRuns, staleMaxAge, staleAction = runs, age, action
+	}(staleAfterRuns, staleMaxAge, staleAction)
+
+	now := time.Date(2021, 3, 3, 10, 0, 0, 0, time.UTC)
+	chk := &ConnectivityCheck{Kind: KindHostPing, Host: "a"}
+	labels := []string{"a"}
+	newVec := func() *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"host"})
+	}
+
+	t.Run("runs", func(t *testing.T) {
+		staleAfterRuns, staleMaxAge, staleAction = 2, 0, staleDelete
+		st := newSeriesTracker()
+		vec := newVec()
+
+		vec.WithLabelValues(labels...).Set(1)
+		st.set(chk, vec, labels, now)
+		st.endRun(chk, now, true)
+		st.endRun(chk, now, true)
+		st.endRun(chk, now, false)
+		if got := testutil.CollectAndCount(vec); got != 1 {
+			t.Fatalf("CollectAndCount after one missed run: got %d, want 1", got)
+		}
+		st.endRun(chk, now, true)
+		if got := testutil.CollectAndCount(vec); got != 0 {
+			t.Errorf("CollectAndCount after two missed runs: got %d, want 0", got)
+		}
+	})
+
+	t.Run("maxAge", func(t *testing.T) {
+		staleAfterRuns, staleMaxAge, staleAction = 0, time.Hour, staleNaN
+		st := newSeriesTracker()
+		vec := newVec()
+
+		vec.WithLabelValues(labels...).Set(1)
+		st.set(chk, vec, labels, now)
+		st.endRun(chk, now, true)
+		st.endRun(chk, now.Add(59*time.Minute), false)
+		if got := testutil.ToFloat64(vec.WithLabelValues(labels...)); got != 1 {
+			t.Fatalf("ToFloat64 before max age: got %v, want 1", got)
+		}
+		st.endRun(chk, now.Add(time.Hour), false)
+		if got := testutil.ToFloat64(vec.WithLabelValues(labels...)); !math.IsNaN(got) {
+			t.Errorf("ToFloat64 after max age: got %v, want NaN", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		staleAfterRuns, staleMaxAge, staleAction = 0, 0, staleDelete
+		st := newSeriesTracker()
+		vec := newVec()
+
+		vec.WithLabelValues(labels...).Set(1)
+		st.set(chk, vec, labels, now)
+		for i := 0; i < 10; i++ {
+			st.endRun(chk, now.AddDate(1, 0, 0), true)
+		}
+		if got := testutil.CollectAndCount(vec); got != 1 {
+			t.Errorf("CollectAndCount: got %d, want 1", got)
+		}
+	})
+}
+
+// failingPingChecker succeeds at pinging until Fail is set.
+type failingPingChecker struct {
+	fakeChecker
+
+	Fail bool
+}
+
+func (c *failingPingChecker) CheckPing(ctx context.Context, network, host string, flood bool, so SocketOptions) (*ping.Statistics, error) {
+	if c.Fail {
+		return nil, errors.New("mocked failure")
+	}
+	return c.fakeChecker.CheckPing(ctx, network, host, flood, so)
+}
+
+func TestRunCheckOnceStale(t *testing.T) {
+	defer func(runs int, m *seriesTracker) {
+		staleAfterRuns, measurements = runs, m
+	}(staleAfterRuns, measurements)
+	staleAfterRuns, measurements = 2, newSeriesTracker()
+
+	chk := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "127.0.0.5"}
+	chkr := &failingPingChecker{}
+	ot := newOutageTracker(chk)
+	if got := runCheckOnce(context.Background(), chk, chkr, ot); got != stateOK {
+		t.Fatalf("runCheckOnce: got %q, want %q", got, stateOK)
+	}
+
+	chkr.Fail = true
+	for i := 0; i < 2; i++ {
+		if got := runCheckOnce(context.Background(), chk, chkr, ot); got != stateFailed {
+			t.Fatalf("runCheckOnce: got %q, want %q", got, stateFailed)
+		}
+	}
+	if hostRTT.DeleteLabelValues(chk.hostLabels()...) {
+		t.Errorf("hostRTT: the stale series was still exported")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	checkTargetInfo    *prometheus.GaugeVec
+	checkTargetChanges *prometheus.CounterVec
+)
+
+func init() {
+	registerCheckVecs(func(keys []string) []prometheus.Collector {
+		checkTargetInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "connectivity",
+			Name:      "check_target_info",
+			Help:      "The address a check last resolved its host to, and its address family.",
+		}, labelNames(serviceLabelNames, keys, "target_ip", "family"))
+		checkTargetChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "connectivity",
+			Name:      "check_target_changes",
+			Help:      "How many times the address a check resolved its host to has changed.",
+		}, labelNames(serviceLabelNames, keys))
+
+		return []prometheus.Collector{checkTargetInfo, checkTargetChanges}
+	})
+}
+
+// checkTargets holds the last resolved target, as the target_ip and
+// family label values, by the label values of the check.
+var checkTargets = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: map[string][]string{}}
+
+// observeTarget updates the target info metric of the check, and
+// counts changes of the resolved address.
+func observeTarget(chk *ConnectivityCheck, addr *net.IPAddr, family string) {
+	labels := chk.serviceLabels()
+	key := strings.Join(labels, "\x00")
+	target := []string{addr.String(), family}
+
+	checkTargets.Lock()
+	prev, ok := checkTargets.m[key]
+	checkTargets.m[key] = target
+	checkTargets.Unlock()
+
+	if ok && (prev[0] != target[0] || prev[1] != target[1]) {
+		checkTargetInfo.DeleteLabelValues(append(labels, prev...)...)
+		checkTargetChanges.WithLabelValues(labels...).Inc()
+		log.Printf("Target of check %s for %s/%s changed from %s to %s", chk.Kind.String(), chk.Network, chk.Host, prev[0], target[0])
+	}
+	checkTargetInfo.WithLabelValues(append(labels, target...)...).Set(1)
+}
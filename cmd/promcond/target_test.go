@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveTarget(t *testing.T) {
+	chk := &ConnectivityCheck{Kind: KindHostPing, Network: "ip", Host: "targettest"}
+	labels := chk.serviceLabels()
+	addr1 := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	addr2 := &net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+
+	observeTarget(chk, addr1, "ip4")
+	observeTarget(chk, addr1, "ip4")
+	if got := testutil.ToFloat64(checkTargetChanges.WithLabelValues(labels...)); got != 0 {
+		t.Errorf("checkTargetChanges: got %v, want 0", got)
+	}
+
+	observeTarget(chk, addr2, "ip6")
+	if got := testutil.ToFloat64(checkTargetChanges.WithLabelValues(labels...)); got != 1 {
+		t.Errorf("checkTargetChanges: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(checkTargetInfo.WithLabelValues(append(labels, "2001:db8::1", "ip6")...)); got != 1 {
+		t.Errorf("checkTargetInfo: got %v, want 1", got)
+	}
+	if checkTargetInfo.DeleteLabelValues(append(labels, "192.0.2.1", "ip4")...) {
+		t.Errorf("checkTargetInfo: the old target was still exported")
+	}
+}
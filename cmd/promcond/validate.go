@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// errInvalidConfig is returned by runValidate if there were problems.
+var errInvalidConfig = errors.New("invalid configuration")
+
+// runValidate implements the validate subcommand. It takes the same
+// flags as the exporter, and reports all problems with the checks,
+// instead of stopping at the first.
+func runValidate(ctx context.Context, args []string, w io.Writer, res targetResolver) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var raw []string
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if f.Name != "check" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Func("check", flag.Lookup("check").Usage, func(s string) error {
+		raw = append(raw, s)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var errs []error
+	var checks []ConnectivityCheck
+	for i, s := range raw {
+		cc, err := parseScheduledCheck(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check %d: %v", i+1, err))
+			continue
+		}
+		checks = append(checks, cc)
+	}
+	if len(raw) == 0 {
+		errs = append(errs, fmt.Errorf("no -check flags provided"))
+	}
+	errs = append(errs, validateChecks(ctx, checks, res)...)
+
+	return reportValidation(w, len(raw), errs)
+}
+
+// A validationWarning is something to point out that isn't a
+// problem.
+type validationWarning struct {
+	error
+}
+
+// reportValidation prints the problems and warnings, if any, and
+// returns errInvalidConfig if there were problems.
+func reportValidation(w io.Writer, nchecks int, errs []error) error {
+	problems := 0
+	for _, err := range errs {
+		if _, ok := err.(validationWarning); ok {
+			fmt.Fprintf(w, "Warning: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(w, "%v\n", err)
+		problems++
+	}
+	if problems == 0 {
+		fmt.Fprintf(w, "Configuration OK: %d checks.\n", nchecks)
+		return nil
+	}
+	fmt.Fprintf(w, "Found %d problems.\n", problems)
+	return errInvalidConfig
+}
+
+// canOpenRawICMP returns whether raw ICMP sockets can be opened. It's
+// a test injection point.
+var canOpenRawICMP = func() bool {
+	return detectCapabilities().RawICMP
+}
+
+// validateChecks returns all problems with parsed checks that would
+// only show up at runtime.
+func validateChecks(ctx context.Context, checks []ConnectivityCheck, res targetResolver) []error {
+	var errs []error
+
+	if expanded, err := expandAllGateways(ctx, checks, res); err != nil {
+		errs = append(errs, err)
+	} else {
+		checks = expanded
+	}
+
+	if err := validateDependencies(checks); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range checks {
+		chk := &checks[i]
+		if chk.Service == "" {
+			continue
+		}
+		if _, err := res.LookupPort(ctx, transportForNetwork(chk.Network, chk.Kind), chk.Service); err != nil {
+			errs = append(errs, fmt.Errorf("check %s: unknown service %q: %v", chk.describe(), chk.Service, err))
+		}
+	}
+
+	errs = append(errs, validateLabelSets(checks)...)
+
+	now := time.Now()
+	for i := range checks {
+		chk := &checks[i]
+		if (len(chk.Allow) > 0 || len(chk.Block) > 0) && chk.nextRun(now).IsZero() {
+			errs = append(errs, fmt.Errorf("check %s never runs: its time windows block all runs", chk.describe()))
+		}
+	}
+
+	for _, chk := range checks {
+		if chk.Kind == KindHostPing || chk.Kind == KindHostFloodPing {
+			if err := checkPingPermission(); err != nil {
+				if canOpenRawICMP() {
+					// The exporter falls back to raw sockets.
+					err = validationWarning{fmt.Errorf("%v; ping checks will use raw ICMP sockets instead", err)}
+				}
+				errs = append(errs, err)
+			}
+			break
+		}
+	}
+
+	return errs
+}
+
+// validateLabelSets returns an error for each check that would
+// export the same series as an earlier check.
+func validateLabelSets(checks []ConnectivityCheck) []error {
+	var errs []error
+	keys := checkLabelKeysOf(checks)
+	seen := map[string]*ConnectivityCheck{}
+	for i := range checks {
+		chk := &checks[i]
+
+		var metric string
+		var labels []string
+		switch chk.Kind {
+		case KindHostPing, KindHostFloodPing:
+			metric, labels = "connectivity_host_rtt", chk.hostLabelValues(keys)
+		case KindNeighbor:
+			metric, labels = "connectivity_neighbor_rtt", chk.hostLabelValues(keys)
+		default:
+			metric, labels = "connectivity_service_latency", chk.serviceLabelValues(keys)
+		}
+		key := metric + "\x00" + strings.Join(labels, "\x00")
+		if prev, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("checks %s and %s both export %s{%s}", prev.describe(), chk.describe(), metric, strings.Join(labels, ",")))
+			continue
+		}
+		seen[key] = chk
+	}
+	return errs
+}
+
+// describe returns the name of the check, or a description if it has
+// no name, followed by its labels.
+func (chk *ConnectivityCheck) describe() string {
+	return reportCheckName(&historyRecord{
+		Name:      chk.Name,
+		Kind:      chk.Kind.String(),
+		Network:   chk.Network,
+		Host:      chk.Host,
+		Service:   chk.Service,
+		Interface: chk.Socket.Interface,
+		Labels:    chk.Labels,
+	})
+}
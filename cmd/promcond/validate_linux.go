@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pingGroupRange is the sysctl of groups allowed to open unprivileged
+// ICMP sockets. It's a test injection point.
+var pingGroupRange = "/proc/sys/net/ipv4/ping_group_range"
+
+// checkPingPermission returns an error if none of the groups of the
+// process is in net.ipv4.ping_group_range, which ping checks need.
+func checkPingPermission() error {
+	bs, err := os.ReadFile(pingGroupRange)
+	if err != nil {
+		return fmt.Errorf("reading ping_group_range: %w", err)
+	}
+	fs := strings.Fields(string(bs))
+	if len(fs) != 2 {
+		return fmt.Errorf("unexpected ping_group_range: %q", bs)
+	}
+	lo, err := strconv.ParseUint(fs[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("unexpected ping_group_range: %w", err)
+	}
+	hi, err := strconv.ParseUint(fs[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("unexpected ping_group_range: %w", err)
+	}
+
+	gids, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	for _, gid := range append(gids, os.Getegid()) {
+		if uint64(gid) >= lo && uint64(gid) <= hi {
+			return nil
+		}
+	}
+	return fmt.Errorf("ping checks need a group in net.ipv4.ping_group_range (%d-%d), but the process has groups %v; try sysctl -w net.ipv4.ping_group_range=\"%d %d\"", lo, hi, append(gids, os.Getegid()), os.Getegid(), os.Getegid())
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPingPermission(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ping_group_range")
+	pgr := pingGroupRange
+	pingGroupRange = path
+	defer func() {
+		pingGroupRange = pgr
+	}()
+
+	if err := os.WriteFile(path, []byte("0\t2147483647\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := checkPingPermission(); err != nil {
+		t.Errorf("checkPingPermission failed: %v", err)
+	}
+
+	// The default disables unprivileged ICMP.
+	if err := os.WriteFile(path, []byte("1\t0\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := checkPingPermission(); err == nil || !strings.Contains(err.Error(), "ping_group_range") {
+		t.Errorf("checkPingPermission: got %v, want ping_group_range error", err)
+	}
+}
+
+func TestValidateChecksRawICMP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ping_group_range")
+	defer func(pgr string, raw func() bool) {
+		pingGroupRange, canOpenRawICMP = pgr, raw
+	}(pingGroupRange, canOpenRawICMP)
+	pingGroupRange = path
+	if err := os.WriteFile(path, []byte("1\t0\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	checks := []ConnectivityCheck{{Kind: KindHostPing, Network: "ip", Host: "localhost", Interval: time.Minute}}
+	for _, raw := range []bool{false, true} {
+		canOpenRawICMP = func() bool { return raw }
+
+		var buf bytes.Buffer
+		err := reportValidation(&buf, len(checks), validateChecks(context.Background(), checks, defaultResolver))
+		if raw {
+			if err != nil || !strings.Contains(buf.String(), "Warning: ") {
+				t.Errorf("reportValidation with raw ICMP: got %v, %q, want a warning", err, buf.String())
+			}
+		} else if err != errInvalidConfig {
+			t.Errorf("reportValidation err: got %v, want %v", err, errInvalidConfig)
+		}
+	}
+}
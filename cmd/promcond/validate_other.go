@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// checkPingPermission tries to open an ICMP socket, since ping checks
+// need one.
+func checkPingPermission() error {
+	conn, err := listenICMP("ip4", SocketOptions{})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
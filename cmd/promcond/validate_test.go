@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunValidate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ok", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runValidate(ctx, []string{"-check", "kind=ping,host=localhost,interval=1m", "-check", "kind=connect,host=localhost,service=echo,interval=1m"}, &buf, defaultResolver); err != nil {
+			t.Fatalf("runValidate failed: %v: %s", err, buf.String())
+		}
+		if want := "Configuration OK: 2 checks.\n"; buf.String() != want {
+			t.Errorf("runValidate: got %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("dryRun", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := runValidate(ctx, []string{"-check", "kind=ping,host=a", "-dry-run", "-check", "kind=bad,host=a,interval=1m"}, &buf, defaultResolver)
+		if err != errInvalidConfig {
+			t.Fatalf("runValidate err: got %v, want %v", err, errInvalidConfig)
+		}
+		if want := "Found 2 problems."; !strings.Contains(buf.String(), want) {
+			t.Errorf("runValidate: got %q, want containing %q", buf.String(), want)
+		}
+	})
+
+	t.Run("names", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runValidate(ctx, []string{"-check", "name=a,kind=ping,host=localhost,interval=1m", "-check", "name=b,kind=flood,host=localhost,interval=1m"}, &buf, defaultResolver); err != nil {
+			t.Fatalf("runValidate failed: %v: %s", err, buf.String())
+		}
+	})
+
+	t.Run("problems", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := runValidate(ctx, []string{
+			"-check", "kind=ping,host=a",
+			"-check", "kind=bad,host=a,interval=1m",
+			"-check", "kind=connect,host=localhost,service=nonexistent-service,interval=1m",
+			"-check", "kind=ping,host=localhost,interval=1m",
+			"-check", "kind=flood,host=localhost,interval=1m",
+			"-check", "kind=ping,host=127.0.0.1,interval=1m,depends_on=gw",
+			"-check", "kind=ping,host=127.0.0.2,interval=1m,allow=01:00-02:00,block=00:00-03:00",
+		}, &buf, defaultResolver)
+		if err != errInvalidConfig {
+			t.Fatalf("runValidate err: got %v, want %v", err, errInvalidConfig)
+		}
+
+		for _, want := range []string{
+			"check 1: missing interval",
+			"check 2: unknown connectivity check kind",
+			`unknown service "nonexistent-service"`,
+			"checks ping ip/localhost and flood ip/localhost both export connectivity_host_rtt{ip,localhost,}",
+			"depends on unknown check gw",
+			"check ping ip/127.0.0.2 never runs",
+			"Found 6 problems.",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("runValidate: got %q, want containing %q", buf.String(), want)
+			}
+		}
+	})
+}